@@ -0,0 +1,150 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// attachment describes a message part which isn't inline text.
+type attachment struct {
+	// ContentType is the part's media type, like "application/pdf".
+	ContentType string
+
+	// Filename is the part's suggested file name, if any.
+	Filename string
+
+	// Size is the number of bytes after decoding the part.
+	Size int64
+}
+
+// readAttachments finds the attachments of the message at path.
+func readAttachments(path string) ([]attachment, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	defer r.Close()
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading message")
+	}
+	return findAttachments(msg.Header, msg.Body)
+}
+
+// findAttachments walks a message part, recursively, collecting the
+// attachments it contains.  Parts are considered attachments when
+// they're marked as such, have a file name or aren't text.
+func findAttachments(header readonlyHeader, body io.Reader) ([]attachment, error) {
+	ct := header.Get("Content-Type")
+	if ct == "" {
+		ct = "text/plain"
+	}
+	ct, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing Content-Type")
+	}
+
+	if strings.HasPrefix(ct, "multipart/") {
+		boundary, ok := params["boundary"]
+		if !ok {
+			return nil, errors.New("multipart/* without boundary")
+		}
+		var attachments []attachment
+		parts := multipart.NewReader(body, boundary)
+		for i := 0; ; i++ {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid multipart message")
+			}
+			if ct == "multipart/signed" && i == 1 {
+				// the signature, not an attachment (RFC 1847)
+				continue
+			}
+			as, err := findAttachments(part.Header, part)
+			if err != nil {
+				return nil, err
+			}
+			attachments = append(attachments, as...)
+		}
+		return attachments, nil
+	}
+
+	filename := params["name"]
+	disposition, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err == nil && dparams["filename"] != "" {
+		filename = dparams["filename"]
+	}
+	if disposition != "attachment" && filename == "" && strings.HasPrefix(ct, "text/") {
+		return nil, nil
+	}
+
+	size, err := io.Copy(ioutil.Discard, decodeTransferEncoding(header, body))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding attachment")
+	}
+	a := attachment{
+		ContentType: ct,
+		Filename:    decodeHeader(filename),
+		Size:        size,
+	}
+	return []attachment{a}, nil
+}
+
+// attachmentMatch is a set of conditions on a single attachment.  The
+// zero value matches any attachment.
+type attachmentMatch struct {
+	// Type is a glob pattern (see path.Match) for the content type.
+	Type string
+
+	// Name is a glob pattern for the file name.
+	Name string
+
+	// Larger, when set, requires attachments to be larger than this.
+	Larger sizeFlag
+}
+
+// Match returns true if the attachment meets all conditions.  Patterns
+// are case insensitive.
+func (m attachmentMatch) Match(a attachment) bool {
+	if m.Type != "" {
+		if ok, _ := path.Match(strings.ToLower(m.Type), a.ContentType); !ok {
+			return false
+		}
+	}
+	if m.Name != "" {
+		if ok, _ := path.Match(strings.ToLower(m.Name), strings.ToLower(a.Filename)); !ok {
+			return false
+		}
+	}
+	if m.Larger.IsSet && a.Size <= m.Larger.Bytes {
+		return false
+	}
+	return true
+}
+
+// attachmentPredicate matches messages having at least one attachment
+// which meets the conditions of m.
+func attachmentPredicate(m attachmentMatch) predicate {
+	return predicateFunc(func(c *candidate) (bool, error) {
+		attachments, err := c.Attachments()
+		if err != nil {
+			return false, err
+		}
+		for _, a := range attachments {
+			if m.Match(a) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,122 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestFindAttachments(t *testing.T) {
+	raw := strings.Join([]string{
+		`Content-Type: multipart/mixed; boundary=XX`,
+		``,
+		`--XX`,
+		`Content-Type: multipart/alternative; boundary=YY`,
+		``,
+		`--YY`,
+		`Content-Type: text/plain`,
+		``,
+		`Hello`,
+		`--YY`,
+		`Content-Type: text/html`,
+		``,
+		`<p>Hello</p>`,
+		`--YY--`,
+		`--XX`,
+		`Content-Type: application/pdf; name="invoice.pdf"`,
+		`Content-Transfer-Encoding: base64`,
+		``,
+		`JVBERi0xLjQK`,
+		`--XX`,
+		`Content-Type: text/csv`,
+		`Content-Disposition: attachment; filename="Report.CSV"`,
+		``,
+		`a,b`,
+		`--XX--`,
+	}, "\r\n")
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("reading message: %s", err)
+	}
+	attachments, err := findAttachments(msg.Header, msg.Body)
+	if err != nil {
+		t.Fatalf("finding attachments: %s", err)
+	}
+	expected := []attachment{
+		{ContentType: "application/pdf", Filename: "invoice.pdf", Size: 9},
+		{ContentType: "text/csv", Filename: "Report.CSV", Size: 3},
+	}
+	if len(attachments) != len(expected) {
+		t.Fatalf("found %d attachments, expected %d", len(attachments), len(expected))
+	}
+	for i := range expected {
+		if attachments[i] != expected[i] {
+			t.Errorf("%+v != %+v", attachments[i], expected[i])
+		}
+	}
+
+	tests := []struct {
+		match    attachmentMatch
+		expected int
+	}{
+		{attachmentMatch{}, 2},
+		{attachmentMatch{Type: "application/*"}, 1},
+		{attachmentMatch{Name: "*.csv"}, 1},
+		{attachmentMatch{Larger: sizeFlag{Bytes: 5, IsSet: true}}, 1},
+		{attachmentMatch{Type: "image/*"}, 0},
+	}
+	for _, test := range tests {
+		n := 0
+		for _, a := range attachments {
+			if test.match.Match(a) {
+				n++
+			}
+		}
+		if n != test.expected {
+			t.Errorf("%+v: matched %d, expected %d", test.match, n, test.expected)
+		}
+	}
+
+	// a signature isn't an attachment
+	signed := strings.Join([]string{
+		`Content-Type: multipart/signed; boundary=SS; micalg=pgp-sha256;`,
+		`  protocol="application/pgp-signature"`,
+		``,
+		`--SS`,
+		`Content-Type: text/plain`,
+		``,
+		`Hello`,
+		`--SS`,
+		`Content-Type: application/pgp-signature; name="signature.asc"`,
+		`Content-Disposition: attachment; filename="signature.asc"`,
+		``,
+		`-----BEGIN PGP SIGNATURE-----`,
+		`--SS--`,
+	}, "\r\n")
+	smime := strings.Replace(signed, "application/pgp-signature", "application/pkcs7-signature", -1)
+	withPDF := strings.Replace(signed, "Content-Type: text/plain\r\n\r\nHello", strings.Join([]string{
+		`Content-Type: multipart/mixed; boundary=XX`,
+		``,
+		`--XX`,
+		`Content-Type: text/plain`,
+		``,
+		`Hello`,
+		`--XX`,
+		`Content-Type: application/pdf; name="invoice.pdf"`,
+		``,
+		`%PDF`,
+		`--XX--`,
+	}, "\r\n"), 1)
+	for raw, expected := range map[string]int{signed: 0, smime: 0, withPDF: 1} {
+		msg, err := mail.ReadMessage(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("reading message: %s", err)
+		}
+		attachments, err := findAttachments(msg.Header, msg.Body)
+		if err != nil {
+			t.Fatalf("finding attachments: %s", err)
+		}
+		if len(attachments) != expected {
+			t.Errorf("found %+v, expected %d attachments", attachments, expected)
+		}
+	}
+}
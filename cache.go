@@ -0,0 +1,119 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// headerCacheName is the file, inside a maildir, which holds its
+// header cache.
+const headerCacheName = ".mailz-headers"
+
+// headerCache remembers the parsed header of each message in a maildir
+// so that CommandHead needn't read unchanged messages again.
+type headerCache struct {
+	// Entries maps a message's unique to its cached header.
+	Entries map[string]*headerCacheEntry
+
+	root  string
+	dirty bool
+	mu    sync.Mutex // guards Entries and dirty
+}
+
+type headerCacheEntry struct {
+	// Size and ModTime describe the message file when its header was
+	// cached.  If either changes, the entry is stale.
+	Size    int64
+	ModTime time.Time
+
+	Header mail.Header
+}
+
+// loadHeaderCache reads the header cache for the maildir at root.  A
+// missing or corrupt cache is treated as empty.
+func loadHeaderCache(root string) (*headerCache, error) {
+	cache := &headerCache{}
+	ok, err := loadGob(filepath.Join(root, headerCacheName), cache)
+	if err != nil {
+		return nil, errors.Wrap(err, "header cache")
+	}
+	if !ok {
+		cache = &headerCache{}
+	}
+	if cache.Entries == nil {
+		cache.Entries = make(map[string]*headerCacheEntry)
+	}
+	cache.root = root
+	return cache, nil
+}
+
+// Header returns the header of the message at path, reading the
+// message only if the cache lacks a fresh copy.
+func (cache *headerCache) Header(path *Path) (mail.Header, error) {
+	info, err := os.Stat(path.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "stat")
+	}
+	cache.mu.Lock()
+	entry := cache.Entries[path.Unique]
+	cache.mu.Unlock()
+	if entry != nil && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+		return entry.Header, nil
+	}
+
+	header, err := readHeader(path.String())
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	cache.Entries[path.Unique] = &headerCacheEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Header:  header,
+	}
+	cache.dirty = true
+	cache.mu.Unlock()
+	return header, nil
+}
+
+// save writes the cache, if it changed, after dropping entries for
+// messages which no longer exist.
+func (cache *headerCache) save() error {
+	if !cache.dirty {
+		return nil
+	}
+
+	present := make(map[string]bool)
+	for _, subdir := range []string{"cur", "new"} {
+		dir, err := os.Open(filepath.Join(cache.root, subdir))
+		if err != nil {
+			return errors.Wrap(err, "opening "+subdir)
+		}
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return errors.Wrap(err, "reading "+subdir)
+		}
+		for _, name := range names {
+			if path, err := ParsePath(filepath.Join(subdir, name)); err == nil {
+				present[path.Unique] = true
+			}
+		}
+	}
+	for unique := range cache.Entries {
+		if !present[unique] {
+			delete(cache.Entries, unique)
+		}
+	}
+
+	err := saveGob(filepath.Join(cache.root, headerCacheName), cache)
+	if err != nil {
+		return errors.Wrap(err, "saving header cache")
+	}
+	cache.dirty = false
+	return nil
+}
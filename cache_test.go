@@ -0,0 +1,117 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeaderCache(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,": "Subject: one\n\nhello\n",
+		"cur/2.a:2,": "Subject: two\n\nhello\n",
+	})
+	defer os.RemoveAll(root)
+	cacheFile := filepath.Join(root, headerCacheName)
+	one, _ := ParsePath(filepath.Join(root, "cur/1.a:2,"))
+	two, _ := ParsePath(filepath.Join(root, "cur/2.a:2,"))
+
+	load := func() *headerCache {
+		cache, err := loadHeaderCache(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cache
+	}
+	subject := func(cache *headerCache, path *Path) string {
+		header, err := cache.Header(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return header.Get("Subject")
+	}
+	save := func(cache *headerCache) {
+		if err := cache.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// fill the cache
+	cache := load()
+	if got := subject(cache, one); got != "one" {
+		t.Errorf("got %q, expected one", got)
+	}
+	subject(cache, two)
+	save(cache)
+
+	// an unchanged file comes from the cache, without reading it
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	rewrite := func(content string, mtime time.Time) {
+		err := ioutil.WriteFile(one.String(), []byte(content), 0600)
+		if err == nil {
+			err = os.Chtimes(one.String(), mtime, mtime)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rewrite("Subject: one\n\nhello\n", mtime)
+	cache = load()
+	subject(cache, one)
+	save(cache)
+	rewrite("Subject: ONE\n\nhello\n", mtime)
+	cache = load()
+	if got := subject(cache, one); got != "one" {
+		t.Errorf("unchanged size and mtime: got %q, expected cached one", got)
+	}
+
+	// a changed mtime or size makes the entry stale
+	rewrite("Subject: ONE\n\nhello\n", mtime.Add(time.Minute))
+	if got := subject(cache, one); got != "ONE" {
+		t.Errorf("changed mtime: got %q, expected ONE", got)
+	}
+	rewrite("Subject: uno\n\nhello there\n", mtime.Add(time.Minute))
+	if got := subject(cache, one); got != "uno" {
+		t.Errorf("changed size: got %q, expected uno", got)
+	}
+
+	// saving drops deleted messages
+	if err := os.Remove(two.String()); err != nil {
+		t.Fatal(err)
+	}
+	save(cache)
+	cache = load()
+	if _, ok := cache.Entries["2.a"]; ok {
+		t.Errorf("entry for deleted message was kept")
+	}
+	if _, ok := cache.Entries["1.a"]; !ok {
+		t.Errorf("entry for existing message was dropped")
+	}
+
+	// saving without changes doesn't write
+	subject(cache, one)
+	if err := os.Remove(cacheFile); err != nil {
+		t.Fatal(err)
+	}
+	save(cache)
+	if _, err := os.Stat(cacheFile); !os.IsNotExist(err) {
+		t.Errorf("unchanged cache was written: %v", err)
+	}
+
+	// a corrupt cache is treated as empty
+	if err := ioutil.WriteFile(cacheFile, []byte("not a gob"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cache = load()
+	if len(cache.Entries) != 0 {
+		t.Errorf("corrupt cache has %d entries", len(cache.Entries))
+	}
+	if got := subject(cache, one); got != "uno" {
+		t.Errorf("corrupt cache: got %q, expected uno", got)
+	}
+	save(cache)
+	if got := subject(load(), one); got != "uno" {
+		t.Errorf("rewritten cache: got %q, expected uno", got)
+	}
+}
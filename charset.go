@@ -0,0 +1,82 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
+)
+
+// wordDecoder decodes RFC 2047 encoded-words in any charset which
+// lookupCharset knows.
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// addressParser parses addresses whose display names may be
+// encoded-words in any charset which lookupCharset knows.
+var addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+
+// parseAddressList parses a raw address header, like To or From.
+func parseAddressList(raw string) ([]*mail.Address, error) {
+	return addressParser.ParseList(raw)
+}
+
+// lookupCharset finds the encoding for a charset name, like
+// "iso-8859-2", "windows-1251", "koi8-r" or "shift_jis".
+func lookupCharset(charset string) (encoding.Encoding, error) {
+	charset = strings.Trim(strings.ToLower(charset), `" `)
+
+	// htmlindex knows the labels mail clients use in practice ...
+	if enc, err := htmlindex.Get(charset); err == nil {
+		return enc, nil
+	}
+
+	// ... and IANA's registry knows the rest
+	if enc, err := ianaindex.MIME.Encoding(charset); err == nil && enc != nil {
+		return enc, nil
+	}
+	return nil, errors.New("unknown charset: " + charset)
+}
+
+// charsetReader converts input in charset to UTF-8.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := lookupCharset(charset)
+	if err != nil {
+		return nil, err
+	}
+	return transform.NewReader(input, enc.NewDecoder()), nil
+}
+
+// decodeText converts text in charset, from a Content-Type parameter,
+// to UTF-8.  Text that's unlabeled, or labeled as ASCII, but has 8bit
+// content which isn't UTF-8 is assumed to be Windows-1252, since that's
+// what most such mail turns out to be.  Text in an unknown charset is
+// left alone.
+func decodeText(r io.Reader, charset string) (io.Reader, error) {
+	switch strings.Trim(strings.ToLower(charset), `" `) {
+	case "utf-8", "utf8":
+		return r, nil
+	case "", "us-ascii", "ascii":
+		text, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if utf8.Valid(text) {
+			return bytes.NewReader(text), nil
+		}
+		return charmap.Windows1252.NewDecoder().Reader(bytes.NewReader(text)), nil
+	}
+
+	if transformed, err := charsetReader(charset, r); err == nil {
+		return transformed, nil
+	}
+	return r, nil
+}
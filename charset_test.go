@@ -0,0 +1,79 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHeaderCharsets(t *testing.T) {
+	tests := map[string]string{
+		`=?ISO-8859-2?Q?Dobr=FD_den?=`:               `Dobrý den`,
+		`=?windows-1251?B?z/Do4uXy?=`:                `Привет`,
+		`=?koi8-r?B?8NLJ18XU?=`:                      `Привет`,
+		`=?windows-1252?Q?=93quoted=94?=`:            `“quoted”`,
+		`=?gb2312?B?xOO6ww==?=`:                      `你好`,
+		`=?shift_jis?B?grGC8YLJgr+CzQ==?=`:           `こんにちは`,
+		`=?iso-2022-jp?B?GyRCJDMkcyRLJEEkTxsoQg==?=`: `こんにちは`,
+		`=?utf-8?Q?caf=C3=A9?= and more`:             `café and more`,
+		`=?x-unknown?Q?abc?=`:                        `=?x-unknown?Q?abc?=`,
+	}
+	for raw, expected := range tests {
+		if got := decodeHeader(raw); got != expected {
+			t.Errorf("%s: got %q, expected %q", raw, got, expected)
+		}
+	}
+}
+
+func TestParseAddressListCharsets(t *testing.T) {
+	addresses, err := parseAddressList(`=?koi8-r?B?8NLJ18XU?= <a@example.com>, =?ISO-8859-2?Q?Dobr=FD?= <b@example.com>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Привет", "Dobrý"}
+	if len(addresses) != len(expected) {
+		t.Fatalf("got %d addresses, expected %d", len(addresses), len(expected))
+	}
+	for i, address := range addresses {
+		if address.Name != expected[i] {
+			t.Errorf("address %d: got %q, expected %q", i, address.Name, expected[i])
+		}
+	}
+}
+
+func TestOutputBodyCharsets(t *testing.T) {
+	tests := []struct {
+		contentType string
+		cte         string
+		body        string
+		expected    string
+	}{
+		{`text/plain; charset=iso-8859-1`, ``, "caf\xe9", `café`},
+		{`text/plain; charset="ISO-8859-15"`, `quoted-printable`, `=A4 5`, `€ 5`},
+		{`text/plain; charset=windows-1251`, `base64`, `z/Do4uXy`, `Привет`},
+		{`text/plain; charset=koi8-r`, ``, "\xf0\xd2\xc9\xd7\xc5\xd4", `Привет`},
+		{`text/plain; charset=utf-8`, ``, `café`, `café`},
+		{`text/plain`, ``, "caf\xe9 \x93ok\x94", `café “ok”`},
+		{`text/plain; charset=us-ascii`, ``, "caf\xe9", `café`},
+		{``, ``, `café`, `café`},
+		{`text/plain; charset=x-unknown`, ``, "caf\xe9", "caf\xe9"},
+	}
+	for _, test := range tests {
+		header := mail.Header{}
+		if test.contentType != "" {
+			header["Content-Type"] = []string{test.contentType}
+		}
+		if test.cte != "" {
+			header["Content-Transfer-Encoding"] = []string{test.cte}
+		}
+		var buf bytes.Buffer
+		err := outputBody(&buf, nil, header, strings.NewReader(test.body))
+		if err != nil {
+			t.Errorf("%s: %s", test.contentType, err)
+			continue
+		}
+		if got := buf.String(); got != test.expected {
+			t.Errorf("%s %q: got %q, expected %q", test.contentType, test.body, got, test.expected)
+		}
+	}
+}
@@ -1,5 +1,6 @@
 package mailz // import "github.com/mndrix/mailz"
 import (
+	"bytes"
 	"encoding/base64"
 	"flag"
 	"fmt"
@@ -8,12 +9,15 @@ import (
 	"mime/multipart"
 	"mime/quotedprintable"
 	"net/mail"
+	"net/textproto"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/mndrix/rand"
@@ -138,7 +142,7 @@ func CommandBody(args []string) error {
 			return errors.Wrap(err, "reading message")
 		}
 
-		err = outputBody(filters, msg.Header, msg.Body)
+		err = outputBody(os.Stdout, filters, msg.Header, msg.Body)
 		if err != nil {
 			return errors.Wrap(err, "outputting message")
 		}
@@ -154,8 +158,18 @@ type readonlyHeader interface {
 
 var errNothingToOutput = errors.New("nothing to output")
 
-// output a message, recursively
-func outputBody(filters map[string]string, header readonlyHeader, body io.Reader) error {
+// decodeTransferEncoding undoes a part's Content-Transfer-Encoding.
+func decodeTransferEncoding(header readonlyHeader, body io.Reader) io.Reader {
+	if cte := header.Get("Content-Transfer-Encoding"); cte == "quoted-printable" {
+		body = quotedprintable.NewReader(body)
+	} else if cte == "base64" {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return body
+}
+
+// output a message to w, recursively
+func outputBody(w io.Writer, filters map[string]string, header readonlyHeader, body io.Reader) error {
 	ct := header.Get("Content-Type")
 	if ct == "" {
 		ct = "text/plain"
@@ -165,17 +179,12 @@ func outputBody(filters map[string]string, header readonlyHeader, body io.Reader
 		return errors.Wrap(err, "parsing Content-Type")
 	}
 
-	// decode body
-	if cte := header.Get("Content-Transfer-Encoding"); cte == "quoted-printable" {
-		body = quotedprintable.NewReader(body)
-	} else if cte == "base64" {
-		body = base64.NewDecoder(base64.StdEncoding, body)
-	}
+	body = decodeTransferEncoding(header, body)
 
 	// does user want an external filter for this content type?
 	if filter, ok := filters[ct]; ok {
 		cmd := exec.Command(filter)
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = w
 		cmd.Stderr = os.Stderr
 		stdin, err := cmd.StdinPipe()
 		if err != nil {
@@ -195,7 +204,11 @@ func outputBody(filters map[string]string, header readonlyHeader, body io.Reader
 
 	switch ct {
 	case "text/plain":
-		_, err = io.Copy(os.Stdout, body)
+		body, err = decodeText(body, params["charset"])
+		if err != nil {
+			return errors.Wrap(err, "decoding charset")
+		}
+		_, err = io.Copy(w, body)
 		if err != nil {
 			return errors.Wrap(err, "copying body to output")
 		}
@@ -215,7 +228,7 @@ func outputBody(filters map[string]string, header readonlyHeader, body io.Reader
 			if err != nil {
 				return errors.Wrap(err, "invalid multipart message")
 			}
-			err = outputBody(filters, part.Header, part)
+			err = outputBody(w, filters, part.Header, part)
 			switch err {
 			case nil:
 				didOutput = true
@@ -234,9 +247,9 @@ func outputBody(filters map[string]string, header readonlyHeader, body io.Reader
 		return errNothingToOutput
 	default:
 		if name := params["name"]; name != "" {
-			fmt.Printf("Attachment %q (%s)\n", params["name"], ct)
+			fmt.Fprintf(w, "Attachment %q (%s)\n", params["name"], ct)
 		} else {
-			fmt.Printf("Attachment (%s)\n", ct)
+			fmt.Fprintf(w, "Attachment (%s)\n", ct)
 		}
 		return nil
 	}
@@ -282,6 +295,7 @@ func CommandCount(folders []string) error {
 	fs := flag.NewFlagSet("count", flag.ContinueOnError)
 	q := &Query{}
 	allowQueryArguments(fs, q)
+	outputJSON := fs.Bool("json", false, `Output a JSON record for each folder`)
 	if err := fs.Parse(folders); err != nil {
 		return errors.Wrap(err, "parsing command line flags")
 	}
@@ -290,13 +304,42 @@ func CommandCount(folders []string) error {
 		return errors.New("Must specify a folder")
 	}
 
+	// with -r, count each maildir beneath the folder separately
+	if q.Recursive {
+		var all []string
+		for _, folder := range folders {
+			search, err := lookupSavedSearch(folder)
+			if err != nil {
+				return err
+			}
+			if search != nil {
+				all = append(all, folder)
+				continue
+			}
+			mds, err := maildirs(folder)
+			if err != nil {
+				return errors.Wrap(err, "finding folders")
+			}
+			all = append(all, mds...)
+		}
+		folders = all
+		q.Recursive = false
+	}
+
 	for _, folder := range folders {
 		q.Root = folder
 		count := 0
 		err := Find(q, func(p *Path) {
 			count++
 		})
-		fmt.Printf("%s\t%d\n", folder, count)
+		if *outputJSON {
+			jerr := writeJSON(os.Stdout, countRecord{Folder: folder, Count: count})
+			if jerr != nil {
+				return errors.Wrap(jerr, "writing JSON")
+			}
+		} else {
+			fmt.Printf("%s\t%d\n", folder, count)
+		}
 		if err != nil {
 			return err
 		}
@@ -305,26 +348,69 @@ func CommandCount(folders []string) error {
 	return nil
 }
 
+// CommandCur moves newly arrived messages into cur/.  Messages in
+// muted conversations are marked seen and trashed along the way, and
+// added to the mute list so replies to them are muted too.
 func CommandCur(paths []string) error {
 	q := &Query{OnlyNew: true}
 	errs := make([]error, 0)
+	var arrived []*candidate
 	for _, path := range paths {
 		q.Root = path
 		err := Find(q, func(p *Path) {
-			src := p.String()
-			p.Cur()
-			dst := p.String()
-			if src == dst {
-				return
+			arrived = append(arrived, &candidate{path: p})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// a muted message may mute others which arrived alongside it, so
+	// keep checking until nothing changes
+	ml := &muteLists{}
+	muted := make(map[*candidate]bool)
+	unchecked := make(map[*candidate]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, c := range arrived {
+			if muted[c] || unchecked[c] {
+				continue
 			}
-			debugf("mv %q %q", src, dst)
-			err := os.Rename(src, dst)
+			m, err := ml.IsMuted(c)
 			if err != nil {
-				errs = append(errs, err)
+				// a message that can't be checked still arrives
+				fmt.Fprintf(os.Stderr, "%s: not checking for muting: %s\n", c.path, err)
+				unchecked[c] = true
+				continue
 			}
-		})
+			if !m {
+				continue
+			}
+			muted[c] = true
+			changed = true
+			err = ml.Mute(c)
+			if err != nil {
+				errs = append(errs, errors.Wrap(err, c.path.String()))
+			}
+		}
+	}
+
+	for _, c := range arrived {
+		p := c.path
+		src := p.String()
+		p.Cur()
+		if muted[c] {
+			p.SetFlag('S')
+			p.SetFlag('T')
+		}
+		dst := p.String()
+		if src == dst {
+			continue
+		}
+		debugf("mv %q %q", src, dst)
+		err := os.Rename(src, dst)
 		if err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
 
@@ -374,6 +460,20 @@ func Deliver(dst string, msg io.Reader, flags string) (string, error) {
 	return final, nil
 }
 
+// CommandMute mutes the conversation of each message.  New messages
+// in a muted conversation are marked seen and trashed by CommandCur.
+// Each conversation is recorded, by the Message-IDs of its messages,
+// in the mute list of the message's maildir.
+func CommandMute(refs []string) error {
+	for _, ref := range refs {
+		_, err := muteRef(ref)
+		if err != nil {
+			return errors.Wrap(err, ref)
+		}
+	}
+	return nil
+}
+
 func CommandResolve(refs []string) error {
 	for _, ref := range refs {
 		if path, err := Resolve(ref); err == nil {
@@ -398,6 +498,16 @@ func (fs *flagList) Set(arg string) error {
 	return nil
 }
 
+type stringList []string
+
+func (ss *stringList) String() string {
+	return strings.Join(*ss, " ")
+}
+func (ss *stringList) Set(arg string) error {
+	*ss = append(*ss, arg)
+	return nil
+}
+
 type Query struct {
 	// Root is the top-level directory where the search should begin.
 	Root string
@@ -413,211 +523,874 @@ type Query struct {
 	// OnlyNew, when true, matches only newly arrived messages.  That
 	// is, messages inside the maildir's new/ directory.
 	OnlyNew bool
+
+	// Headers is a slice of header conditions which must all be met
+	// for a message to match.
+	Headers headerMatchList
+
+	// Since, when not zero, matches only messages received at or
+	// after this time.  See candidate.Time for how a message's time
+	// is determined.
+	Since timeBound
+
+	// Before, when not zero, matches only messages received before
+	// this time.
+	Before timeBound
+
+	// Body, when not nil, matches only messages whose decoded body
+	// text matches this pattern.  The text is the same as what
+	// CommandBody outputs.
+	Body regexpFlag
+
+	// Expr, when set, is a query expression which messages must
+	// match.  See queryExpr for the syntax.
+	Expr queryExpr
+
+	// Recursive, when true, searches every maildir beneath Root
+	// instead of just Root itself.
+	Recursive bool
+
+	// Larger, when set, matches only messages larger than this many
+	// bytes.
+	Larger sizeFlag
+
+	// Smaller, when set, matches only messages smaller than this many
+	// bytes.
+	Smaller sizeFlag
+
+	// Jobs is the number of messages to examine concurrently.  Values
+	// less than 2 examine one message at a time.
+	Jobs int
+
+	// HasAttachment, when true, matches only messages with an
+	// attachment which meets the conditions in Attachment.
+	HasAttachment bool
+
+	// Attachment holds conditions on a single attachment.  Setting
+	// any of them implies HasAttachment.
+	Attachment attachmentMatch
+
+	// Muted, when true, matches only messages in conversations which
+	// have been muted.  See CommandMute.
+	Muted bool
+
+	// searches lists the saved searches being expanded, outermost
+	// first, so a saved search which refers to itself can be caught.
+	searches []string
 }
 
 func allowQueryArguments(fs *flag.FlagSet, q *Query) {
 	fs.Var(&q.FlagClear, "c", `Match when these flags are clear, like "ST"`)
 	fs.Var(&q.FlagSet, "s", `Match when these flags are set, like "ST"`)
 	fs.BoolVar(&q.OnlyNew, "N", false, `Match only newly arrived messages`)
+	fs.Var(&q.Headers, "m", `Match a header, like "From:alice" or "Subject:/^re:/"`)
+	fs.Var(&q.Since, "since", `Match messages received since a time, like "7d" or "2018-05-01"`)
+	fs.Var(&q.Before, "before", `Match messages received before a time, like "90d" or "2018-05-01"`)
+	fs.Var(&q.Body, "body", `Match messages whose body matches a regular expression`)
+	fs.Var(&q.Expr, "q", `Match a query expression, like "(from:alice or from:bob) and not flag:T"`)
+	fs.BoolVar(&q.Recursive, "r", false, `Search every maildir beneath each folder`)
+	fs.Var(&q.Larger, "larger", `Match messages larger than a size, like "5M"`)
+	fs.Var(&q.Smaller, "smaller", `Match messages smaller than a size, like "10k"`)
+	fs.IntVar(&q.Jobs, "j", 1, `Examine this many messages concurrently`)
+	fs.BoolVar(&q.HasAttachment, "attachment", false, `Match messages with an attachment`)
+	fs.StringVar(&q.Attachment.Type, "attachment-type", "", `Match messages with an attachment of this type, like "image/*"`)
+	fs.StringVar(&q.Attachment.Name, "attachment-name", "", `Match messages with an attachment of this name, like "*.pdf"`)
+	fs.Var(&q.Attachment.Larger, "attachment-larger", `Match messages with an attachment larger than a size, like "5M"`)
+	fs.BoolVar(&q.Muted, "muted", false, `Match messages in muted conversations`)
 }
 
-func CommandFind(folders []string) error {
-	fs := flag.NewFlagSet("find", flag.ContinueOnError)
-	query := &Query{}
-	allowQueryArguments(fs, query)
-	if err := fs.Parse(folders); err != nil {
-		return errors.Wrap(err, "parsing command line flags")
+// sizeFlag is a number of bytes given on the command line.  See
+// parseSize for the format.
+type sizeFlag struct {
+	Bytes int64
+
+	// IsSet is true if the flag appeared on the command line.
+	IsSet bool
+}
+
+func (f *sizeFlag) String() string {
+	if !f.IsSet {
+		return ""
 	}
-	folders = fs.Args()
-	if len(folders) == 0 {
-		folders = []string{"."}
+	return strconv.FormatInt(f.Bytes, 10)
+}
+func (f *sizeFlag) Set(arg string) error {
+	n, err := parseSize(arg)
+	if err != nil {
+		return err
 	}
-	for _, folder := range folders {
-		query.Root = folder
-		err := Find(query, func(path *Path) {
-			fmt.Println(path)
-		})
-		if err != nil {
-			return err
-		}
+	f.Bytes, f.IsSet = n, true
+	return nil
+}
+
+// regexpFlag is a regular expression given on the command line.
+type regexpFlag struct {
+	*regexp.Regexp
+}
+
+func (r *regexpFlag) String() string {
+	if r.Regexp == nil {
+		return ""
+	}
+	return r.Regexp.String()
+}
+func (r *regexpFlag) Set(arg string) error {
+	rx, err := regexp.Compile(arg)
+	if err != nil {
+		return err
 	}
+	r.Regexp = rx
 	return nil
 }
 
-func debugf(format string, args ...interface{}) {
-	if true {
-		return
+// timeBound is a point in time given on the command line, either as
+// an age relative to now (like "36h", "7d" or "2w") or as an absolute
+// date (like "2018-05-01" or RFC 3339).
+type timeBound struct {
+	time.Time
+}
+
+func (b *timeBound) String() string {
+	if b.IsZero() {
+		return ""
 	}
-	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	return b.Format(time.RFC3339)
+}
+func (b *timeBound) Set(arg string) error {
+	t, err := parseTimeBound(arg, time.Now())
+	if err != nil {
+		return err
+	}
+	b.Time = t
+	return nil
 }
 
-func Find(query *Query, fn func(*Path)) error {
-	// handle a single file system entry
-	handleEntry := func(p string, entry os.FileInfo) {
-		if entry.IsDir() {
-			return
-		}
-		path, err := ParsePath(p)
-		if err != nil {
-			return
-		}
+func parseTimeBound(arg string, now time.Time) (time.Time, error) {
+	// an absolute time?
+	if t, err := time.Parse(time.RFC3339, arg); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", arg, time.Local); err == nil {
+		return t, nil
+	}
 
-		// are flag conditions met?
-		for _, flag := range query.FlagClear {
-			if !path.IsClear(flag) {
-				return
-			}
+	// an age, with units beyond those of time.ParseDuration?
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	for suffix, unit := range units {
+		if !strings.HasSuffix(arg, suffix) {
+			continue
 		}
-		for _, flag := range query.FlagSet {
-			if !path.IsSet(flag) {
-				return
-			}
+		if n, err := strconv.Atoi(strings.TrimSuffix(arg, suffix)); err == nil {
+			return now.Add(-time.Duration(n) * unit), nil
 		}
-
-		fn(path)
+	}
+	if d, err := time.ParseDuration(arg); err == nil {
+		return now.Add(-d), nil
 	}
 
-	// iterate messages in a single file system directory
-	walkDir := func(subdir string) error {
-		// are we only searching the new/ directory?
-		if query.OnlyNew && filepath.Base(subdir) != "new" {
-			debugf("OnlyNew skip: %q", subdir)
-			return nil
-		}
+	return time.Time{}, fmt.Errorf("invalid time: %q", arg)
+}
 
-		dir, err := os.Open(subdir)
-		if err != nil {
-			return err
-		}
-		defer dir.Close()
-		for {
-			entries, err := dir.Readdir(2) // TODO increase after testing
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return errors.Wrap(err, "reading directory entries")
-			}
-			for _, entry := range entries {
-				p := filepath.ToSlash(filepath.Join(subdir, entry.Name()))
-				handleEntry(p, entry)
-			}
-		}
-		return nil
+// headerMatch is a condition on the decoded value of a header.  The
+// condition is met if any occurrence of the header matches Pattern.
+type headerMatch struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// parseHeaderMatch parses a condition like "From:alice".  The value
+// is a case-insensitive substring unless it's surrounded by slashes,
+// in which case it's a regular expression.
+func parseHeaderMatch(arg string) (headerMatch, error) {
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return headerMatch{}, fmt.Errorf("expected Name:value, got %q", arg)
 	}
+	name, value := parts[0], parts[1]
 
-	entry, err := os.Stat(query.Root)
-	if os.IsNotExist(err) {
-		query.Root, err = Resolve(query.Root)
-		if err == nil {
-			entry, err = os.Stat(query.Root)
-		}
+	var expr string
+	if len(value) >= 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		expr = value[1 : len(value)-1]
+	} else {
+		expr = "(?i)" + regexp.QuoteMeta(value)
 	}
+	rx, err := regexp.Compile(expr)
 	if err != nil {
-		return errors.Wrap(err, "root missing")
+		return headerMatch{}, errors.Wrap(err, "compiling header pattern")
 	}
-	if !entry.IsDir() {
-		handleEntry(query.Root, entry)
-		return nil
+	return headerMatch{Name: name, Pattern: rx}, nil
+}
+
+func (m headerMatch) String() string {
+	return m.Name + ":/" + m.Pattern.String() + "/"
+}
+
+// Match returns true if this condition is met by a message header.
+func (m headerMatch) Match(header mail.Header) bool {
+	for _, raw := range header[textproto.CanonicalMIMEHeaderKey(m.Name)] {
+		if m.Pattern.MatchString(decodeHeader(raw)) {
+			return true
+		}
 	}
+	return false
+}
 
-	err = walkDir(filepath.Join(query.Root, "cur"))
-	if err != nil {
-		return errors.Wrap(err, "Counting cur")
+type headerMatchList []headerMatch
+
+func (ms *headerMatchList) String() string {
+	strs := make([]string, len(*ms))
+	for i, m := range *ms {
+		strs[i] = m.String()
 	}
-	err = walkDir(filepath.Join(query.Root, "new"))
+	return strings.Join(strs, " ")
+}
+func (ms *headerMatchList) Set(arg string) error {
+	m, err := parseHeaderMatch(arg)
 	if err != nil {
-		return errors.Wrap(err, "Counting new")
+		return err
 	}
-
+	*ms = append(*ms, m)
 	return nil
 }
 
-// CommandFlags changes the flags for each message.  For example,
-//
-//    mailz flags -s SRT -c F path/to/cur/message
-//
-// sets the flags S, R, and T while clearing the F flag.
-func CommandFlags(args []string) error {
-	fs := flag.NewFlagSet("flags", flag.ContinueOnError)
-	var clear = fs.String("c", "", `A string of flags to clear, like "ST"`)
-	var set = fs.String("s", "", `A string of flags to set, like "ST"`)
-	if err := fs.Parse(args); err != nil {
-		return errors.Wrap(err, "parsing command line flags")
-	}
-	plus := []rune(*set)
-	minus := []rune(*clear)
+// candidate is a message being considered by Find.  Details which
+// require reading the message file are loaded on demand.
+type candidate struct {
+	path   *Path
+	info   os.FileInfo
+	header mail.Header
+	text   []byte
+	index  *lazyIndex
+
+	attachments     []attachment
+	attachmentsRead bool
+}
 
-	var paths []*Path
-	//fmt.Printf("flags args: %+v\n", fs.Args())
-	for _, arg := range fs.Args() {
-		if arg == "" {
-			continue
-		}
-		path, err := Resolve(arg)
+// Header returns the message's header, reading it on first use.
+func (c *candidate) Header() (mail.Header, error) {
+	if c.header == nil && c.index != nil {
+		c.header = c.index.Header(c.path.Unique)
+	}
+	if c.header == nil {
+		header, err := readHeader(c.path.String())
 		if err != nil {
-			return errors.Wrap(err, "resolve ref")
+			return nil, err
 		}
-		p, err := ParsePath(path)
+		c.header = header
+	}
+	return c.header, nil
+}
+
+// Info returns the file system details of the message file.
+func (c *candidate) Info() (os.FileInfo, error) {
+	if c.info == nil {
+		info, err := os.Stat(c.path.String())
 		if err != nil {
-			return errors.Wrap(err, "parse path")
+			return nil, errors.Wrap(err, "stat")
 		}
-		paths = append(paths, p)
+		c.info = info
 	}
+	return c.info, nil
+}
 
-	// calculate new names
-	oldPaths := make([]string, len(paths))
-	newPaths := make([]string, len(paths))
-	for i, path := range paths {
-		oldPaths[i] = path.String()
-		for _, flag := range minus {
-			path.ClearFlag(flag)
-		}
-		for _, flag := range plus {
-			path.SetFlag(flag)
+// Size returns the size of the message in bytes.  The size recorded
+// in the message's filename is preferred, since it's cheaper than
+// examining the file.
+func (c *candidate) Size() (int64, error) {
+	if size, ok := c.path.Size(); ok {
+		return size, nil
+	}
+	info, err := c.Info()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Time returns the time this message was received.  That's the date
+// on the most recent Received header, falling back to the Date header
+// and finally to the file's modification time.
+func (c *candidate) Time() (time.Time, error) {
+	header, err := c.Header()
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, name := range []string{"Received", "Date"} {
+		if v := header.Get(name); v != "" {
+			if t, err := parseHeaderTime(name, v); err == nil {
+				return t, nil
+			}
 		}
-		newPaths[i] = path.String()
 	}
 
-	// TODO remove after debugging
-	//fmt.Printf("would add: %s\n", string(plus))
-	//fmt.Printf("would remove: %s\n", string(minus))
-	//fmt.Printf("old paths: %+v\n", oldPaths)
-	//fmt.Printf("new paths: %+v\n", newPaths)
+	info, err := c.Info()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
 
-	// rename files
-	for i := range oldPaths {
-		err := os.Rename(oldPaths[i], newPaths[i])
+// Text returns the message's decoded body text.  See extractText.
+func (c *candidate) Text() ([]byte, error) {
+	if c.text == nil {
+		text, err := readText(c.path.String())
 		if err != nil {
-			return errors.Wrap(err, "renaming")
+			return nil, err
 		}
+		c.text = text
 	}
-
-	return nil
+	return c.text, nil
 }
 
-type columnSpec struct {
-	Name   string
-	Filter func(*Path, string, string) string
+// Attachments returns the message's attachments.
+func (c *candidate) Attachments() ([]attachment, error) {
+	if !c.attachmentsRead {
+		attachments, err := readAttachments(c.path.String())
+		if err != nil {
+			return nil, err
+		}
+		c.attachments = attachments
+		c.attachmentsRead = true
+	}
+	return c.attachments, nil
 }
 
-func typeAddress(p *Path, h, v string) string {
-	addresses, err := mail.ParseAddressList(v)
+// readText decodes the body text of the message at path.
+func readText(path string) ([]byte, error) {
+	r, err := os.Open(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid address: %q\n", v)
-		return "Error <error>"
+		return nil, errors.Wrap(err, "open")
 	}
-	strs := make([]string, len(addresses))
-	for i, address := range addresses {
-		strs[i] = address.String()
+	defer r.Close()
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading message")
 	}
-	return strings.Join(strs, ", ")
-}
 
-func typeAddressName(p *Path, h, v string) string {
-	addresses, err := mail.ParseAddressList(v)
+	var buf bytes.Buffer
+	err = extractText(&buf, msg.Header, msg.Body)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid address: %q\n", v)
+		return nil, errors.Wrap(err, "decoding body")
+	}
+	return buf.Bytes(), nil
+}
+
+// extractText writes the decoded text/plain parts of a message body to
+// w, for searching.  Unlike outputBody, it writes nothing for other
+// parts, such as attachments, and ends each part with a newline so
+// the last line of one part doesn't run into the first of the next.
+// Parts with a malformed Content-Type are skipped.
+func extractText(w io.Writer, header readonlyHeader, body io.Reader) error {
+	ct := header.Get("Content-Type")
+	if ct == "" {
+		ct = "text/plain"
+	}
+	ct, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil
+	}
+
+	switch {
+	case ct == "text/plain":
+		text, err := decodeText(decodeTransferEncoding(header, body), params["charset"])
+		if err != nil {
+			return errors.Wrap(err, "decoding charset")
+		}
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, text)
+		if err != nil {
+			return errors.Wrap(err, "reading text")
+		}
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+	case strings.HasPrefix(ct, "multipart/"):
+		boundary, ok := params["boundary"]
+		if !ok {
+			return nil
+		}
+		parts := multipart.NewReader(decodeTransferEncoding(header, body), boundary)
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrap(err, "invalid multipart message")
+			}
+			err = extractText(w, part.Header, part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readHeader parses the header of the message at path.
+func readHeader(path string) (mail.Header, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	defer r.Close()
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading message")
+	}
+	return msg.Header, nil
+}
+
+// decodeHeader decodes RFC 2047 encoded-words in a header value.  If
+// decoding fails, the raw value is returned.
+func decodeHeader(raw string) string {
+	if decoded, err := wordDecoder.DecodeHeader(raw); err == nil {
+		return decoded
+	}
+	return raw
+}
+
+// CommandFind outputs the path of each message matching a query.  For
+// example,
+//
+//    mailz find -c T -sort date -reverse -limit 20 inbox
+//
+// shows the 20 most recent messages without the T flag.
+func CommandFind(folders []string) error {
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	query := &Query{}
+	allowQueryArguments(fs, query)
+	sortKey := fs.String("sort", "", `Sort by date, received, from, subject, size or unique`)
+	reverse := fs.Bool("reverse", false, `Sort in descending order`)
+	limit := fs.Int("limit", -1, `Output at most this many messages`)
+	offset := fs.Int("offset", 0, `Skip this many messages before output`)
+	outputJSON := fs.Bool("json", false, `Output a JSON record for each message`)
+	if err := fs.Parse(folders); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	folders = fs.Args()
+	if len(folders) == 0 {
+		folders = []string{"."}
+	}
+	if *offset < 0 {
+		return errors.New("-offset must not be negative")
+	}
+	var outputErr error
+	output := func(path *Path) {
+		if !*outputJSON {
+			fmt.Println(path)
+		} else if outputErr == nil {
+			outputErr = writeJSON(os.Stdout, newPathRecord(path))
+		}
+	}
+
+	// without sorting or paging, output messages as they're found
+	if *sortKey == "" && *limit < 0 && *offset == 0 {
+		for _, folder := range folders {
+			query.Root = folder
+			err := Find(query, output)
+			if err != nil {
+				return err
+			}
+			if outputErr != nil {
+				return errors.Wrap(outputErr, "writing JSON")
+			}
+		}
+		return nil
+	}
+
+	var paths []*Path
+	for _, folder := range folders {
+		query.Root = folder
+		err := Find(query, func(path *Path) {
+			paths = append(paths, path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if *sortKey != "" {
+		err := sortPaths(paths, *sortKey, *reverse)
+		if err != nil {
+			return errors.Wrap(err, "sorting")
+		}
+	}
+	if *offset > len(paths) {
+		*offset = len(paths)
+	}
+	paths = paths[*offset:]
+	if *limit >= 0 && *limit < len(paths) {
+		paths = paths[:*limit]
+	}
+	for _, path := range paths {
+		output(path)
+	}
+	if outputErr != nil {
+		return errors.Wrap(outputErr, "writing JSON")
+	}
+	return nil
+}
+
+// CommandDedupe finds copies of the same message within a folder.  For
+// each duplicate, it outputs the duplicate's path and the path of the
+// copy being kept.  For example,
+//
+//    mailz dedupe -c T -T inbox
+//
+// marks all but one copy of each message with the T flag.  The kept
+// copy receives the flags of all its duplicates.  See duplicateKey for
+// how copies are recognized.
+func CommandDedupe(args []string) error {
+	fs := flag.NewFlagSet("dedupe", flag.ContinueOnError)
+	q := &Query{}
+	allowQueryArguments(fs, q)
+	trash := fs.Bool("T", false, `Set the T flag on duplicates`)
+	remove := fs.Bool("d", false, `Delete duplicates`)
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	if *trash && *remove {
+		return errors.New("-T and -d are mutually exclusive")
+	}
+	folders := fs.Args()
+	if len(folders) == 0 {
+		folders = []string{"."}
+	}
+
+	// group copies by folder and key
+	type group struct {
+		key    string
+		copies []*Path
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+	for _, folder := range folders {
+		q.Root = folder
+		err := Find(q, func(path *Path) {
+			key, err := duplicateKey(path.String())
+			if err != nil {
+				// leave it alone, since it can't be compared
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+				return
+			}
+			key = path.Prefix + "\x00" + key
+			g, ok := byKey[key]
+			if !ok {
+				g = &group{key: key}
+				byKey[key] = g
+				groups = append(groups, g)
+			}
+			g.copies = append(g.copies, path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, g := range groups {
+		if len(g.copies) < 2 {
+			continue
+		}
+		sort.Sort(byKeeper(g.copies))
+		keep, duplicates := g.copies[0], g.copies[1:]
+
+		// report duplicates
+		oldKeep := keep.String()
+		for _, dup := range duplicates {
+			fmt.Printf("%s\t%s\n", dup, oldKeep)
+		}
+		if !*trash && !*remove {
+			continue
+		}
+
+		// preserve flags on the kept copy ...
+		keep.Flags = mergeFlags(g.copies)
+		if newKeep := keep.String(); newKeep != oldKeep {
+			err := os.Rename(oldKeep, newKeep)
+			if err != nil {
+				return errors.Wrap(err, "renaming")
+			}
+		}
+
+		// ... then dispose of the duplicates
+		for _, dup := range duplicates {
+			if *remove {
+				err := os.Remove(dup.String())
+				if err != nil {
+					return errors.Wrap(err, "removing")
+				}
+				continue
+			}
+			oldDup := dup.String()
+			dup.SetFlag('T')
+			if newDup := dup.String(); newDup != oldDup {
+				err := os.Rename(oldDup, newDup)
+				if err != nil {
+					return errors.Wrap(err, "renaming")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func debugf(format string, args ...interface{}) {
+	if true {
+		return
+	}
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// Find calls fn for each message matching query.  A message which
+// can't be read doesn't match, and a warning goes to stderr, so one bad
+// message doesn't spoil a search of the whole folder.
+func Find(query *Query, fn func(*Path)) error {
+	pred := query.predicate()
+	var idx *lazyIndex // index for the maildir being walked
+
+	// decide whether a single file system entry is a matching
+	// message.  If not, the path is nil.
+	check := func(p string, entry os.FileInfo) *Path {
+		if entry.IsDir() {
+			return nil
+		}
+		path, err := ParsePath(p)
+		if err != nil {
+			return nil
+		}
+
+		// are the query's conditions met?
+		c := &candidate{path: path, info: entry, index: idx}
+		ok, err := pred.match(c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+		return path
+	}
+
+	// handle a single file system entry
+	handleEntry := func(p string, entry os.FileInfo) {
+		if path := check(p, entry); path != nil {
+			fn(path)
+		}
+	}
+
+	// iterate messages in a single file system directory
+	walkDir := func(subdir string) error {
+		// are we only searching the new/ directory?
+		if query.OnlyNew && filepath.Base(subdir) != "new" {
+			debugf("OnlyNew skip: %q", subdir)
+			return nil
+		}
+
+		dir, err := os.Open(subdir)
+		if err != nil {
+			return err
+		}
+		defer dir.Close()
+
+		// examine entries concurrently, calling fn in directory order
+		if query.Jobs > 1 {
+			entries, err := dir.Readdir(-1)
+			if err != nil {
+				return errors.Wrap(err, "reading directory entries")
+			}
+			paths := make([]*Path, len(entries))
+			inOrder(query.Jobs, len(entries), func(i int) {
+				p := filepath.ToSlash(filepath.Join(subdir, entries[i].Name()))
+				paths[i] = check(p, entries[i])
+			}, func(i int) {
+				if paths[i] != nil {
+					fn(paths[i])
+				}
+			})
+			return nil
+		}
+
+		for {
+			entries, err := dir.Readdir(2) // TODO increase after testing
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrap(err, "reading directory entries")
+			}
+			for _, entry := range entries {
+				p := filepath.ToSlash(filepath.Join(subdir, entry.Name()))
+				handleEntry(p, entry)
+			}
+		}
+		return nil
+	}
+
+	entry, err := os.Stat(query.Root)
+	if os.IsNotExist(err) {
+		// maybe it's a saved search
+		search, serr := lookupSavedSearch(query.Root)
+		if serr != nil {
+			return serr
+		}
+		if search != nil {
+			return search.find(query, fn)
+		}
+
+		query.Root, err = Resolve(query.Root)
+		if err == nil {
+			entry, err = os.Stat(query.Root)
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "root missing")
+	}
+	if !entry.IsDir() {
+		handleEntry(query.Root, entry)
+		return nil
+	}
+
+	roots := []string{query.Root}
+	if query.Recursive {
+		roots, err = maildirs(query.Root)
+		if err != nil {
+			return errors.Wrap(err, "finding folders")
+		}
+	}
+	for _, root := range roots {
+		idx = &lazyIndex{root: root}
+		err = walkDir(filepath.Join(root, "cur"))
+		if err != nil {
+			return errors.Wrap(err, "Counting cur")
+		}
+		err = walkDir(filepath.Join(root, "new"))
+		if err != nil {
+			return errors.Wrap(err, "Counting new")
+		}
+	}
+
+	return nil
+}
+
+// CommandFlags changes the flags for each message.  For example,
+//
+//    mailz flags -s SRT -c F path/to/cur/message
+//
+// sets the flags S, R, and T while clearing the F flag.  With -t,
+// the changes apply to every message in each message's conversation
+// (see Threads).  For example,
+//
+//    mailz flags -t -f ../archive -s ST path/to/cur/message
+//
+// marks a conversation as seen and trashed, including any of its
+// messages in the archive folder.
+func CommandFlags(args []string) error {
+	fs := flag.NewFlagSet("flags", flag.ContinueOnError)
+	var clear = fs.String("c", "", `A string of flags to clear, like "ST"`)
+	var set = fs.String("s", "", `A string of flags to set, like "ST"`)
+	var wholeThread = fs.Bool("t", false, `Change every message in the conversation`)
+	var folders stringList
+	fs.Var(&folders, "f", `With -t, also look for the conversation in this folder`)
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	plus := []rune(*set)
+	minus := []rune(*clear)
+
+	var paths []*Path
+	//fmt.Printf("flags args: %+v\n", fs.Args())
+	for _, arg := range fs.Args() {
+		if arg == "" {
+			continue
+		}
+		path, err := Resolve(arg)
+		if err != nil {
+			return errors.Wrap(err, "resolve ref")
+		}
+		p, err := ParsePath(path)
+		if err != nil {
+			return errors.Wrap(err, "parse path")
+		}
+		paths = append(paths, p)
+	}
+	if *wholeThread {
+		var err error
+		paths, err = expandThreads(paths, folders)
+		if err != nil {
+			return errors.Wrap(err, "finding conversations")
+		}
+	}
+
+	// calculate new names
+	oldPaths := make([]string, len(paths))
+	newPaths := make([]string, len(paths))
+	for i, path := range paths {
+		oldPaths[i] = path.String()
+		for _, flag := range minus {
+			path.ClearFlag(flag)
+		}
+		for _, flag := range plus {
+			path.SetFlag(flag)
+		}
+		newPaths[i] = path.String()
+	}
+
+	// TODO remove after debugging
+	//fmt.Printf("would add: %s\n", string(plus))
+	//fmt.Printf("would remove: %s\n", string(minus))
+	//fmt.Printf("old paths: %+v\n", oldPaths)
+	//fmt.Printf("new paths: %+v\n", newPaths)
+
+	// rename files
+	for i := range oldPaths {
+		err := os.Rename(oldPaths[i], newPaths[i])
+		if err != nil {
+			return errors.Wrap(err, "renaming")
+		}
+	}
+
+	return nil
+}
+
+type columnSpec struct {
+	Name   string
+	Filter func(*headView, string, string) string
+
+	// Value, if not nil, gives the column's value for JSON output.
+	// Otherwise, the output of Filter is used.
+	Value func(*headView, string, string) interface{}
+
+	// Key names a column, which isn't a header, in JSON output.
+	Key string
+
+	// All is true if the column has a field for every occurrence of
+	// its header, rather than just the first.
+	All bool
+}
+
+func typeAddress(m *headView, h, v string) string {
+	addresses, err := parseAddressList(m.field(h))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid address: %q\n", v)
+		return "Error <error>"
+	}
+	strs := make([]string, len(addresses))
+	for i, address := range addresses {
+		strs[i] = address.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+func typeAddressName(m *headView, h, v string) string {
+	addresses, err := parseAddressList(m.field(h))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid address: %q\n", v)
 		return "Error <error>"
 	}
 	strs := make([]string, len(addresses))
@@ -627,8 +1400,8 @@ func typeAddressName(p *Path, h, v string) string {
 	return strings.Join(strs, ", ")
 }
 
-func typeAddressEmail(p *Path, h, v string) string {
-	addresses, err := mail.ParseAddressList(v)
+func typeAddressEmail(m *headView, h, v string) string {
+	addresses, err := parseAddressList(m.field(h))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid address: %q\n", v)
 		return "Error <error>"
@@ -640,41 +1413,166 @@ func typeAddressEmail(p *Path, h, v string) string {
 	return strings.Join(strs, ", ")
 }
 
-func typeIdentifier(p *Path, h, v string) string {
-	return p.Unique
+func typeBaseSubject(m *headView, h, v string) string {
+	return normalizeSubject(v)
+}
+
+func typeIdentifier(m *headView, h, v string) string {
+	return m.path.Unique
+}
+
+func typeFlags(m *headView, h, v string) string {
+	return m.path.FlagString()
 }
 
-func typeFlags(p *Path, h, v string) string {
-	return p.FlagString()
+func typeSize(m *headView, h, v string) string {
+	c := &candidate{path: m.path}
+	size, err := c.Size()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid size: %s\n", err)
+		return ""
+	}
+	return strconv.FormatInt(size, 10)
 }
 
-func typeString(p *Path, h, v string) string {
+func typeString(m *headView, h, v string) string {
 	return v
 }
 
-func typeTime(p *Path, h, v string) string {
+func typeFolder(m *headView, h, v string) string {
+	return m.path.Folder()
+}
+
+func typePath(m *headView, h, v string) string {
+	return m.path.String()
+}
+
+func typeState(m *headView, h, v string) string {
+	return m.path.State
+}
+
+// parseHeaderTime parses the date in header h with value v.  For
+// Received headers, that's the date after the final semicolon.
+func parseHeaderTime(h, v string) (time.Time, error) {
+	h, _, _ = splitHeaderIndex(h)
 	if strings.ToLower(h) == "received" {
 		i := strings.LastIndex(v, ";")
 		v = strings.TrimSpace(v[i+1:])
 	}
-	t, err := mail.ParseDate(v)
+	return mail.ParseDate(v)
+}
+
+var headerIndexRx = regexp.MustCompile(`^(.+)\[(-?\d+)\]$`)
+
+// splitHeaderIndex splits a header name like "Received[-1]" into the
+// name and an index.  ok is false if there's no index.
+func splitHeaderIndex(name string) (h string, i int, ok bool) {
+	matches := headerIndexRx.FindStringSubmatch(name)
+	if matches == nil {
+		return name, 0, false
+	}
+	i, err := strconv.Atoi(matches[2])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid date: %q\n", v)
+		return name, 0, false
+	}
+	return matches[1], i, true
+}
+
+// headerField returns the raw value of a header.  A name like
+// "Received[i]" chooses the i-th occurrence of the header, counting
+// from 0.  Negative indexes count back from the last occurrence, so
+// "Received[-1]" is the hop where a message originated.
+func headerField(header mail.Header, name string) string {
+	h, i, ok := splitHeaderIndex(name)
+	if !ok {
+		return header.Get(name)
+	}
+	values := header[textproto.CanonicalMIMEHeaderKey(h)]
+	if i < 0 {
+		i += len(values)
+	}
+	if i < 0 || i >= len(values) {
+		return ""
+	}
+	return values[i]
+}
+
+// CommandGrep outputs the path of each message whose decoded body
+// matches a regular expression.  For example,
+//
+//    mailz grep -c T -lines invoice inbox
+//
+// shows matching lines from each message without the T flag.
+func CommandGrep(args []string) error {
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	q := &Query{}
+	allowQueryArguments(fs, q)
+	ignoreCase := fs.Bool("i", false, `Ignore case when matching`)
+	showLines := fs.Bool("lines", false, `Output matching lines after each path`)
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return errors.New("Must specify a pattern")
+	}
+	pattern, folders := args[0], args[1:]
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	if err := q.Body.Set(pattern); err != nil {
+		return errors.Wrap(err, "compiling pattern")
+	}
+	if len(folders) == 0 {
+		folders = []string{"."}
+	}
+
+	for _, folder := range folders {
+		q.Root = folder
+		err := Find(q, func(path *Path) {
+			if !*showLines {
+				fmt.Println(path)
+				return
+			}
+
+			// find matching lines (reading the text again is
+			// simpler than plumbing it out of Find)
+			text, err := readText(path.String())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+				return
+			}
+			for _, line := range bytes.Split(text, []byte("\n")) {
+				line = bytes.TrimSuffix(line, []byte("\r"))
+				if q.Body.Match(line) {
+					fmt.Printf("%s:%s\n", path, line)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
 	}
-	return t.UTC().Format(time.RFC3339)
+	return nil
 }
 
 func CommandHead(args []string) error {
 	// parse command line arguments
 	showFieldName := false
 	hideEmptyFields := false
+	useCache := false
+	outputJSON := false
+	var tmpl *template.Template
+	timeFmt := defaultTimeFormat
+	timeSources := make(map[int]string) // column index -> time source
+	jobs := 1
 	outputFieldSeparator := "\t"
 	columns := make([]columnSpec, 0)
 	paths := make([]*Path, 0)
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 		switch arg {
-		case "-a", "-E", "-N", "-s", "-t":
+		case "-a", "-A", "-b", "-E", "-l", "-lm", "-lw", "-N", "-s", "-t":
 			i++
 			if i >= len(args) {
 				return errors.New(arg + " needs an argument")
@@ -685,23 +1583,74 @@ func CommandHead(args []string) error {
 			switch arg {
 			case "-a":
 				column.Filter = typeAddress
+				column.Value = jsonAddress
+			case "-A":
+				column.Filter = typeString
+				column.All = true
+			case "-b":
+				column.Filter = typeBaseSubject
 			case "-E":
 				column.Filter = typeAddressEmail
+				column.Value = jsonAddress
+			case "-l":
+				column.Filter = typeListTargets
+				column.Value = jsonListTargets
+			case "-lm":
+				column.Filter = typeListMailto
+				column.Value = jsonListMailto
+			case "-lw":
+				column.Filter = typeListWeb
+				column.Value = jsonListWeb
 			case "-N":
 				column.Filter = typeAddressName
+				column.Value = jsonAddress
 			case "-s":
 				column.Filter = typeString
 			case "-t":
-				column.Filter = typeTime
+				timeSources[len(columns)] = column.Name
 			default:
 				panic("incomplete case statement")
 			}
 			columns = append(columns, column)
+		case "-d":
+			column := columnSpec{
+				Filter: typeFolder,
+			}
+			columns = append(columns, column)
+		case "-D", "-m":
+			column := columnSpec{
+				Key: sourceDelivered,
+			}
+			if arg == "-m" {
+				column.Key = sourceModified
+			}
+			timeSources[len(columns)] = column.Key
+			columns = append(columns, column)
 		case "-f":
 			column := columnSpec{
 				Filter: typeFlags,
 			}
 			columns = append(columns, column)
+		case "-fallback":
+			i++
+			if i >= len(args) {
+				return errors.New(arg + " needs an argument")
+			}
+			timeFmt.Fallback = strings.Split(args[i], ",")
+		case "-layout":
+			i++
+			if i >= len(args) {
+				return errors.New(arg + " needs an argument")
+			}
+			timeFmt.setLayout(args[i])
+		case "-tz":
+			i++
+			if i >= len(args) {
+				return errors.New(arg + " needs an argument")
+			}
+			if err := timeFmt.setZone(args[i]); err != nil {
+				return errors.Wrap(err, "parsing "+arg)
+			}
 		case "-F":
 			i++
 			if i >= len(args) {
@@ -711,6 +1660,8 @@ func CommandHead(args []string) error {
 				args[i] = ors
 			}
 			outputFieldSeparator = args[i]
+		case "-C":
+			useCache = true
 		case "-H":
 			showFieldName = true
 		case "-i":
@@ -718,6 +1669,45 @@ func CommandHead(args []string) error {
 				Filter: typeIdentifier,
 			}
 			columns = append(columns, column)
+		case "-format", "--format":
+			i++
+			if i >= len(args) {
+				return errors.New(arg + " needs an argument")
+			}
+			var err error
+			tmpl, err = parseHeadTemplate(args[i])
+			if err != nil {
+				return errors.Wrap(err, "parsing template")
+			}
+		case "-json", "--json":
+			outputJSON = true
+		case "-j":
+			i++
+			if i >= len(args) {
+				return errors.New(arg + " needs an argument")
+			}
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return errors.Wrap(err, "parsing "+arg)
+			}
+			jobs = n
+		case "-p":
+			column := columnSpec{
+				Filter: typePath,
+			}
+			columns = append(columns, column)
+		case "-S":
+			column := columnSpec{
+				Filter: typeSize,
+				Value:  jsonSize,
+				Key:    "size",
+			}
+			columns = append(columns, column)
+		case "-w":
+			column := columnSpec{
+				Filter: typeState,
+			}
+			columns = append(columns, column)
 		case "-z":
 			hideEmptyFields = true
 		default:
@@ -736,36 +1726,185 @@ func CommandHead(args []string) error {
 		}
 	}
 
-	// parse the header from each path
-	var wordDecoder = new(mime.WordDecoder)
-	for _, path := range paths {
-		r, err := os.Open(path.String())
-		if err != nil {
-			return errors.Wrap(err, "open")
+	// -layout, -tz and -fallback apply to every time column, wherever
+	// they appear
+	for i, source := range timeSources {
+		columns[i].Filter, columns[i].Value = timeFmt.column(source)
+	}
+
+	if outputJSON && tmpl != nil {
+		return errors.New("-json and -format can't be used together")
+	}
+	if outputJSON {
+		// JSON records key each value by header name, so a header
+		// can't have two columns
+		seen := make(map[string]bool)
+		for _, column := range columns {
+			if column.Name == "" {
+				continue
+			}
+			if seen[column.Name] {
+				return errors.New("-json can't output header " + column.Name + " more than once")
+			}
+			seen[column.Name] = true
+		}
+	}
+
+	// load header caches for each path's maildir
+	caches := make(map[string]*headerCache)
+	if useCache {
+		for _, path := range paths {
+			if _, ok := caches[path.Prefix]; ok {
+				continue
+			}
+			cache, err := loadHeaderCache(path.Prefix)
+			if err != nil {
+				return err
+			}
+			caches[path.Prefix] = cache
+		}
+	}
+
+	// parse the header from each path (or fetch it from the cache)
+	lines := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	format := func(path *Path) (string, error) {
+		var header mail.Header
+		var err error
+		if useCache {
+			header, err = caches[path.Prefix].Header(path)
+		} else {
+			header, err = readHeader(path.String())
 		}
-		msg, err := mail.ReadMessage(r)
 		if err != nil {
-			return errors.Wrap(err, "reading message")
+			return "", err
 		}
-		r.Close()
+		if outputJSON {
+			return formatHeadJSON(path, header, columns)
+		}
+		if tmpl != nil {
+			return formatHeadTemplate(tmpl, path, header)
+		}
+		view := &headView{path: path, header: header}
 		values := make([]string, 0, len(columns))
-		for _, column := range columns {
-			raw := msg.Header.Get(column.Name)
-			if decoded, err := wordDecoder.DecodeHeader(raw); err == nil {
-				raw = decoded
-			}
-			value := column.Filter(path, column.Name, raw)
+		add := func(name, value string) {
 			if hideEmptyFields && value == "" {
-				continue
+				return
 			}
 			if showFieldName {
-				value = column.Name + ": " + value
+				value = name + ": " + value
 			}
 			values = append(values, value)
 		}
-		fmt.Println(strings.Join(values, outputFieldSeparator))
+		for _, column := range columns {
+			if column.All {
+				for i, raw := range view.Headers(column.Name) {
+					name := fmt.Sprintf("%s[%d]", column.Name, i)
+					add(name, column.Filter(view, name, raw))
+				}
+				continue
+			}
+			raw := view.Header(column.Name)
+			add(column.Name, column.Filter(view, column.Name, raw))
+		}
+		return strings.Join(values, outputFieldSeparator), nil
+	}
+	var err error
+	inOrder(jobs, len(paths), func(i int) {
+		lines[i], errs[i] = format(paths[i])
+	}, func(i int) {
+		if err == nil {
+			err = errs[i]
+		}
+		if err == nil {
+			fmt.Println(lines[i])
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, cache := range caches {
+		err := cache.save()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatHeadJSON formats the requested columns of a message's header
+// as a JSON record.
+func formatHeadJSON(path *Path, header mail.Header, columns []columnSpec) (string, error) {
+	view := &headView{path: path, header: header}
+	record := headRecord{
+		pathRecord: newPathRecord(path),
+		Headers:    make(map[string]interface{}),
+	}
+	for _, column := range columns {
+		if column.Name == "" {
+			// the path record already covers columns without a key
+			switch column.Key {
+			case "size":
+				record.Size = column.Value(view, "", "")
+			case sourceModified:
+				record.Modified = column.Value(view, "", "")
+			case sourceDelivered:
+				record.Delivered = column.Value(view, "", "")
+			}
+			continue
+		}
+		if column.All {
+			all := view.Headers(column.Name)
+			if all == nil {
+				all = []string{}
+			}
+			record.Headers[column.Name] = all
+			continue
+		}
+		raw := view.Header(column.Name)
+		if column.Value != nil {
+			record.Headers[column.Name] = column.Value(view, column.Name, raw)
+		} else {
+			record.Headers[column.Name] = column.Filter(view, column.Name, raw)
+		}
 	}
 
+	var buf bytes.Buffer
+	err := writeJSON(&buf, record)
+	return strings.TrimSuffix(buf.String(), "\n"), err
+}
+
+// CommandIndex creates or updates the search index of each maildir.
+// Find uses an index, when one exists, to avoid reading messages.
+func CommandIndex(args []string) error {
+	fs := flag.NewFlagSet("index", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, `Index every maildir beneath each folder`)
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	folders := fs.Args()
+	if len(folders) == 0 {
+		folders = []string{"."}
+	}
+
+	for _, folder := range folders {
+		roots := []string{folder}
+		if *recursive {
+			var err error
+			roots, err = maildirs(folder)
+			if err != nil {
+				return errors.Wrap(err, "finding folders")
+			}
+		}
+		for _, root := range roots {
+			idx, err := updateIndex(root)
+			if err != nil {
+				return errors.Wrap(err, "indexing "+root)
+			}
+			fmt.Printf("%s\t%d\n", root, len(idx.Entries))
+		}
+	}
 	return nil
 }
 
@@ -790,6 +1929,99 @@ func CommandMove(args []string) error {
 	return nil
 }
 
+// CommandSearch outputs the path of each message containing all of the
+// given words, using (and updating) each maildir's index.  For
+// example,
+//
+//    mailz search -c T "quarterly invoice" inbox
+func CommandSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	q := &Query{}
+	allowQueryArguments(fs, q)
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return errors.New("Must specify search terms")
+	}
+	terms, folders := searchTerms(args[0]), args[1:]
+	if len(folders) == 0 {
+		folders = []string{"."}
+	}
+
+	// bring each maildir's index up to date when its first message
+	// is found, so folders can be anything Find accepts
+	indexes := make(map[string]*index)
+	var errs []error
+	for _, folder := range folders {
+		q.Root = folder
+		err := Find(q, func(path *Path) {
+			root := path.Folder()
+			idx, ok := indexes[root]
+			if !ok {
+				var err error
+				idx, err = updateIndex(root)
+				if err != nil {
+					errs = append(errs, errors.Wrap(err, "indexing "+root))
+				}
+				indexes[root] = idx
+			}
+			if idx == nil {
+				return
+			}
+			if entry := idx.Entries[path.Unique]; entry != nil && entry.HasTerms(terms) {
+				fmt.Println(path)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if len(errs) > 0 {
+			return errs[0]
+		}
+	}
+	return nil
+}
+
+// CommandThread outputs the messages of each folder arranged into
+// conversations.  Each reply is indented beneath its parent.  A
+// message which is referenced but missing is shown by its Message-ID
+// in angle brackets.  See Threads.
+func CommandThread(args []string) error {
+	fs := flag.NewFlagSet("thread", flag.ContinueOnError)
+	q := &Query{}
+	allowQueryArguments(fs, q)
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	folders := fs.Args()
+	if len(folders) == 0 {
+		folders = []string{"."}
+	}
+
+	for _, folder := range folders {
+		q.Root = folder
+		threads, err := Threads(q)
+		if err != nil {
+			return err
+		}
+		for _, t := range threads {
+			t.Walk(func(t *Thread, depth int) {
+				indent := strings.Repeat("  ", depth)
+				if t.Path == nil && t.MessageID == "" {
+					fmt.Printf("%s(subject: %s)\n", indent, t.Subject)
+				} else if t.Path == nil {
+					fmt.Printf("%s<%s>\n", indent, t.MessageID)
+				} else {
+					fmt.Printf("%s%s\n", indent, t.Path)
+				}
+			})
+		}
+	}
+	return nil
+}
+
 // CommandUnique outputs, for each message path, the unique portion of
 // the message's path.  See Unique.
 func CommandUnique(paths []string) error {
@@ -0,0 +1,121 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// volatileHeaders are added or changed by mail tools as a message is
+// synced or filed, so they're ignored when comparing content.
+var volatileHeaders = map[string]bool{
+	"Content-Length": true,
+	"Lines":          true,
+	"Status":         true,
+	"X-Keywords":     true,
+	"X-Status":       true,
+	"X-Tuid":         true,
+	"X-Uid":          true,
+}
+
+// duplicateKey returns a string which is the same for all copies of
+// the message at path.  That's its Message-ID or, lacking one, a hash
+// of its content.
+func duplicateKey(path string) (string, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "open")
+	}
+	defer r.Close()
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return "", errors.Wrap(err, "reading message")
+	}
+
+	if id := normalizeMessageID(msg.Header.Get("Message-Id")); id != "" {
+		return "id:" + id, nil
+	}
+
+	// hash the header, ignoring order and volatile fields ...
+	hash := sha256.New()
+	names := make([]string, 0, len(msg.Header))
+	for name := range msg.Header {
+		if !volatileHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range msg.Header[name] {
+			io.WriteString(hash, name+": "+strings.TrimSpace(value)+"\n")
+		}
+	}
+
+	// ... and the body, ignoring line endings and trailing space
+	hash.Write([]byte("\n"))
+	lines := bufio.NewScanner(msg.Body)
+	lines.Buffer(nil, 1<<20)
+	for lines.Scan() {
+		hash.Write(bytes.TrimRight(lines.Bytes(), " \t\r"))
+		hash.Write([]byte("\n"))
+	}
+	if err := lines.Err(); err != nil {
+		return "", errors.Wrap(err, "reading body")
+	}
+	return "hash:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// normalizeMessageID strips white space and angle brackets from a
+// Message-ID.
+func normalizeMessageID(id string) string {
+	id = strings.TrimSpace(id)
+	id = strings.TrimPrefix(id, "<")
+	id = strings.TrimSuffix(id, ">")
+	return strings.TrimSpace(id)
+}
+
+// byKeeper sorts copies of a message so the one worth keeping comes
+// first.  Copies without the T flag are preferred, followed by those
+// in cur/ since they've been seen.
+type byKeeper []*Path
+
+func (ps byKeeper) Len() int      { return len(ps) }
+func (ps byKeeper) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+func (ps byKeeper) Less(i, j int) bool {
+	if ps[i].IsSet('T') != ps[j].IsSet('T') {
+		return ps[j].IsSet('T')
+	}
+	if ps[i].State != ps[j].State {
+		return ps[i].State == "cur"
+	}
+	return ps[i].String() < ps[j].String()
+}
+
+// mergeFlags returns the flags the kept copy of a message should have:
+// the union of the flags of all copies.  T is the exception.  It's only
+// kept if every copy has it, so trashing some duplicates doesn't
+// trash the original.
+func mergeFlags(copies []*Path) map[rune]bool {
+	flags := make(map[rune]bool)
+	trashed := true
+	for _, p := range copies {
+		for flag, ok := range p.Flags {
+			if ok {
+				flags[flag] = true
+			}
+		}
+		trashed = trashed && p.IsSet('T')
+	}
+	if !trashed {
+		delete(flags, 'T')
+	}
+	return flags
+}
@@ -0,0 +1,68 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCommandDedupe(t *testing.T) {
+	messages := map[string]string{
+		// the copy in cur/ is kept, gaining F but not T
+		"new/1.a:2,":   "Message-ID: <a@x>\nSubject: a\n\nhi\n",
+		"cur/2.a:2,S":  "Message-ID: <a@x>\nSubject: a\n\nhi\n",
+		"cur/3.a:2,FT": "Message-ID: <a@x>\nSubject: a\n\nhi\n",
+
+		// every copy is trashed, so the kept one stays trashed
+		"cur/4.a:2,RT": "Message-ID: <b@x>\nSubject: b\n\nhi\n",
+		"cur/5.a:2,T":  "Message-ID: <b@x>\nSubject: b\n\nhi\n",
+
+		// no Message-ID, so copies are found by content
+		"cur/6.a:2,": "Subject: c\nStatus: RO\n\nhi\n",
+		"new/7.a:2,": "Subject: c\n\nhi  \n",
+
+		"cur/8.a:2,": "Subject: d\n\nhi\n",
+	}
+	report := []string{
+		"cur/3.a:2,FT\tcur/2.a:2,S",
+		"cur/5.a:2,T\tcur/4.a:2,RT",
+		"new/1.a:2,\tcur/2.a:2,S",
+		"new/7.a:2,\tcur/6.a:2,",
+	}
+	tests := map[string][]string{
+		"-T": {
+			"cur/2.a:2,FS",
+			"cur/3.a:2,FT",
+			"cur/4.a:2,RT",
+			"cur/5.a:2,T",
+			"cur/6.a:2,",
+			"cur/8.a:2,",
+			"new/1.a:2,T",
+			"new/7.a:2,T",
+		},
+		"-d": {
+			"cur/2.a:2,FS",
+			"cur/4.a:2,RT",
+			"cur/6.a:2,",
+			"cur/8.a:2,",
+		},
+	}
+	for flag, expected := range tests {
+		root := testMaildir(t, messages)
+		defer os.RemoveAll(root)
+
+		got := captureStdout(t, func() error { return CommandDedupe([]string{flag, root}) })
+		got = strings.Replace(got, root+string(filepath.Separator), "", -1)
+		lines := strings.Split(strings.TrimSpace(got), "\n")
+		sort.Strings(lines)
+		if !reflect.DeepEqual(lines, report) {
+			t.Errorf("%s: reported %q, expected %q", flag, lines, report)
+		}
+
+		if names := maildirNames(t, root); !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: got %q, expected %q", flag, names, expected)
+		}
+	}
+}
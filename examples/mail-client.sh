@@ -15,7 +15,6 @@
 set -e
 MAIL=~/Mail
 readonly message_list="mailz-message-list.txt"
-readonly mute_list="${MAIL}/muted.txt"
 
 choose_a_folder() {
     local folder
@@ -87,8 +86,7 @@ list() {
 generate_list() {
     mailz cur .
     mailz find -c T \
-        | xargs mailz head -i -s Subject -N From -E From -t Received -f \
-        | perl -ne '@F=split /\t/; $F[1]=~s/^re: +//i; print join("\t",@F);' \
+        | xargs mailz head -i -b Subject -N From -E From -t Received -f \
         | sort -t "$(printf '\t')" -f -k 2,2 -k 5,5 \
         | awk '
                 BEGIN { FS=OFS="\t" }
@@ -218,9 +216,9 @@ w
 EOF
 }
 
-# mute emails whose Subject matches this message's Subject
-mute_subject() {
-    mailz head -s Subject "$1" | perl -pe 's/^re: +//i' >>"${mute_list}"
+# mute the conversation containing this message
+mute_thread() {
+    mailz mute "$1"
 }
 
 # display a message
@@ -247,7 +245,11 @@ print() {
             } | ${PAGER:-more}
             ;;
         verbose)
-            ${PAGER:-more} "${path}"
+            {
+                mailz headers -o "${path}";
+                echo;
+                mailz body "${path}";
+            } | ${PAGER:-more}
             ;;
     esac
 }
@@ -280,12 +282,11 @@ unsubscribe() {
     esac
 }
 unsubscribe_url() {
-    mailz head -s List-Unsubscribe $1 \
-        | awk -F '[<>, ]+' '
-            $2~/https?:/ { print $2; exit; }
-            $3~/https?:/ { print $3; exit; }
-            { print $2, "\n", $3; exit; }
-        '
+    local url="$(mailz head -lw List-Unsubscribe $1 | awk -F ', ' '{ print $1 }')"
+    if [[ -z $url ]]; then
+        url="$(mailz head -lm List-Unsubscribe $1 | awk -F ', ' '{ print $1 }')"
+    fi
+    echo "${url}"
 }
 
 # select a folder
@@ -352,7 +353,7 @@ while key="$(getkey)"; do
             ;;
         m)
             id="$(selected_message)"
-            mute_subject "${id}"
+            mute_thread "${id}"
             mark_message_as_done "${id}"
             echo "Muted"
             if move_cursor "+"; then
@@ -0,0 +1,138 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testMaildir creates a temporary maildir holding messages, which maps
+// a path, like "cur/1.a:2,S", to the message's content.  The caller
+// should remove the maildir when done.
+func testMaildir(t *testing.T, messages map[string]string) string {
+	root, err := ioutil.TempDir("", "mailz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subdir := range []string{"cur", "new", "tmp"} {
+		err = os.Mkdir(filepath.Join(root, subdir), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range messages {
+		err = ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// findNames returns the names, relative to the maildir, of the
+// messages matching query.
+func findNames(t *testing.T, query *Query) []string {
+	var names []string
+	err := Find(query, func(path *Path) {
+		name, err := filepath.Rel(query.Root, path.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.ToSlash(name))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFindUnreadable(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,": "From: alice@example.com\nSubject: one\n\nhello\n",
+		"cur/2.a:2,": "From: alice@example.com\nthis is not a header\n\nhello\n",
+		"new/3.a:2,": "From: bob@example.com\nSubject: three\n\nhello\n",
+	})
+	defer os.RemoveAll(root)
+
+	query := &Query{Root: root}
+	query.Headers.Set("From:alice")
+	got := findNames(t, query)
+	expected := []string{"cur/1.a:2,"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+
+	// without content predicates, unreadable messages still match
+	got = findNames(t, &Query{Root: root})
+	expected = []string{"cur/1.a:2,", "cur/2.a:2,", "new/3.a:2,"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestFindSavedSearchLoop(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,F": "Subject: one\n\nhello\n",
+	})
+	defer os.RemoveAll(root)
+	searches := "flagged -s F .\n" +
+		"loop -s F loop\n" +
+		"ping -s F pong\n" +
+		"pong -c S ping\n"
+	err := ioutil.WriteFile(filepath.Join(root, savedSearchesName), []byte(searches), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := findNames(t, &Query{Root: filepath.Join(root, "flagged")})
+	if len(got) != 1 {
+		t.Errorf("flagged: got %q", got)
+	}
+	for _, name := range []string{"loop", "ping"} {
+		err := Find(&Query{Root: filepath.Join(root, name)}, func(*Path) {})
+		if err == nil {
+			t.Errorf("%s: expected a loop error", name)
+		}
+	}
+}
+
+// captureStdout returns what fn writes to stdout.
+func captureStdout(t *testing.T, fn func() error) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	output := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		output <- b
+	}()
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	b := <-output
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// maildirNames returns the sorted names of the messages in the maildir
+// at root, relative to root.
+func maildirNames(t *testing.T, root string) []string {
+	names, err := filepath.Glob(filepath.Join(root, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		names[i], _ = filepath.Rel(root, name)
+		names[i] = filepath.ToSlash(names[i])
+	}
+	sort.Strings(names)
+	return names
+}
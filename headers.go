@@ -0,0 +1,172 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// rawHeader is a single header field as it appears in a message.
+type rawHeader struct {
+	Name string
+
+	// Value is the field's unfolded value, without any decoding.
+	Value string
+}
+
+// readRawHeaders reads a message's header fields in the order they
+// appear, which mail.ReadMessage doesn't preserve.  Folded values are
+// unfolded.
+func readRawHeaders(r io.Reader) ([]rawHeader, error) {
+	var headers []rawHeader
+	lines := bufio.NewReader(r)
+	for {
+		line, err := lines.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "" {
+			return headers, nil // end of the header
+		}
+
+		if trimmed[0] == ' ' || trimmed[0] == '\t' {
+			// a continuation line
+			if len(headers) > 0 {
+				h := &headers[len(headers)-1]
+				h.Value = strings.TrimSpace(h.Value + " " + strings.TrimSpace(trimmed))
+			}
+		} else if i := strings.Index(trimmed, ":"); i > 0 {
+			headers = append(headers, rawHeader{
+				Name:  strings.TrimSpace(trimmed[:i]),
+				Value: strings.TrimSpace(trimmed[i+1:]),
+			})
+		}
+
+		if err == io.EOF {
+			return headers, nil
+		}
+	}
+}
+
+// headerWeeding chooses which headers to show, like mutt's ignore and
+// unignore commands.  Patterns are case-insensitive prefixes of header
+// names and "*" matches every header.
+type headerWeeding struct {
+	Ignore   []string
+	Unignore []string
+}
+
+func matchHeaderPattern(pattern, name string) bool {
+	pattern = strings.TrimSuffix(pattern, ":")
+	return pattern == "*" || strings.HasPrefix(strings.ToLower(name), strings.ToLower(pattern))
+}
+
+// Show returns true if the named header should be shown.
+func (w *headerWeeding) Show(name string) bool {
+	for _, pattern := range w.Unignore {
+		if pattern != "*" && matchHeaderPattern(pattern, name) {
+			return true
+		}
+	}
+	for _, pattern := range w.Ignore {
+		if matchHeaderPattern(pattern, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Weed removes ignored headers.  Unless keepOrder is true, headers
+// which were unignored come first, in the order of their patterns, like
+// mutt's hdr_order.
+func (w *headerWeeding) Weed(headers []rawHeader, keepOrder bool) []rawHeader {
+	var shown []rawHeader
+	for _, h := range headers {
+		if w.Show(h.Name) {
+			shown = append(shown, h)
+		}
+	}
+	if keepOrder {
+		return shown
+	}
+
+	var ordered []rawHeader
+	used := make([]bool, len(shown))
+	for _, pattern := range w.Unignore {
+		if pattern == "*" {
+			continue
+		}
+		for i, h := range shown {
+			if !used[i] && matchHeaderPattern(pattern, h.Name) {
+				ordered = append(ordered, h)
+				used[i] = true
+			}
+		}
+	}
+	for i, h := range shown {
+		if !used[i] {
+			ordered = append(ordered, h)
+		}
+	}
+	return ordered
+}
+
+// CommandHeaders outputs every header of each message, unfolded and
+// decoded.  For example,
+//
+//    mailz headers -i '*' -u From -u To -u Subject -u Date message
+//
+// shows a message's main headers in that order.  With -o, headers keep
+// their original order.  With -r, each decoded value is followed by a
+// tab and the raw value.
+func CommandHeaders(args []string) error {
+	fs := flag.NewFlagSet("headers", flag.ContinueOnError)
+	var weeding headerWeeding
+	fs.Var((*stringList)(&weeding.Ignore), "i", `Ignore headers starting with this, or "*" for all`)
+	fs.Var((*stringList)(&weeding.Unignore), "u", `Show headers starting with this, even if ignored`)
+	keepOrder := fs.Bool("o", false, `Keep the headers' original order`)
+	showRaw := fs.Bool("r", false, `Output each raw value after the decoded one`)
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return errors.New("Must specify a message")
+	}
+
+	for i, arg := range args {
+		path, err := Resolve(arg)
+		if err != nil {
+			return errors.Wrap(err, "resolve")
+		}
+		r, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "open")
+		}
+		headers, err := readRawHeaders(r)
+		r.Close()
+		if err != nil {
+			return errors.Wrap(err, "reading header")
+		}
+
+		var buf bytes.Buffer
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		for _, h := range weeding.Weed(headers, *keepOrder) {
+			fmt.Fprintf(&buf, "%s: %s", h.Name, decodeHeader(h.Value))
+			if *showRaw {
+				fmt.Fprintf(&buf, "\t%s", h.Value)
+			}
+			buf.WriteString("\n")
+		}
+		os.Stdout.Write(buf.Bytes())
+	}
+	return nil
+}
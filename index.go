@@ -0,0 +1,305 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// indexName is the file, inside a maildir, which holds the search
+// terms of its index.  indexHeadersName holds the headers.  They're
+// kept apart so Find can use the headers without decoding the terms.
+const (
+	indexName        = ".mailz-index"
+	indexHeadersName = ".mailz-index-headers"
+)
+
+// index holds parsed details about each message in a maildir so they
+// can be searched without reading every message file.  Messages never
+// change after delivery, so entries stay valid for as long as their
+// unique exists.
+type index struct {
+	// Entries maps a message's unique to its details.
+	Entries map[string]*indexEntry
+}
+
+// indexTerms and indexHeaders are how an index is stored on disk.
+type indexTerms struct {
+	Terms map[string][]string
+}
+type indexHeaders struct {
+	Headers map[string]mail.Header
+}
+
+type indexEntry struct {
+	// Header is the message's header.
+	Header mail.Header
+
+	// Terms is a sorted list of the distinct, lowercase words found
+	// in the message's body and main headers.
+	Terms []string
+}
+
+// indexedHeaders are the headers whose words are included in an
+// entry's Terms.
+var indexedHeaders = []string{"From", "To", "Cc", "Subject"}
+
+// HasTerms returns true if every word in terms occurs in the message.
+func (e *indexEntry) HasTerms(terms []string) bool {
+	for _, term := range terms {
+		i := sort.SearchStrings(e.Terms, term)
+		if i >= len(e.Terms) || e.Terms[i] != term {
+			return false
+		}
+	}
+	return true
+}
+
+// loadIndex reads the index for the maildir at root.  If the maildir
+// has no index, it returns nil without an error.
+func loadIndex(root string) (*index, error) {
+	headers, err := loadIndexHeaders(root)
+	if err != nil || headers == nil {
+		return nil, err
+	}
+	terms := &indexTerms{}
+	ok, err := loadGob(filepath.Join(root, indexName), terms)
+	if err != nil {
+		return nil, errors.Wrap(err, "index")
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	idx := &index{Entries: make(map[string]*indexEntry)}
+	for unique, header := range headers {
+		idx.Entries[unique] = &indexEntry{
+			Header: header,
+			Terms:  terms.Terms[unique],
+		}
+	}
+	return idx, nil
+}
+
+// loadIndexHeaders reads just the headers from the index for the
+// maildir at root, keyed by unique.  If the maildir has no index, it
+// returns nil without an error.
+func loadIndexHeaders(root string) (map[string]mail.Header, error) {
+	headers := &indexHeaders{}
+	ok, err := loadGob(filepath.Join(root, indexHeadersName), headers)
+	if err != nil {
+		return nil, errors.Wrap(err, "index headers")
+	}
+	if !ok || headers.Headers == nil {
+		return nil, nil
+	}
+	return headers.Headers, nil
+}
+
+// save writes the index for the maildir at root.
+func (idx *index) save(root string) error {
+	terms := &indexTerms{Terms: make(map[string][]string)}
+	headers := &indexHeaders{Headers: make(map[string]mail.Header)}
+	for unique, entry := range idx.Entries {
+		terms.Terms[unique] = entry.Terms
+		headers.Headers[unique] = entry.Header
+	}
+	err := saveGob(filepath.Join(root, indexName), terms)
+	if err != nil {
+		return err
+	}
+	return saveGob(filepath.Join(root, indexHeadersName), headers)
+}
+
+// lazyIndex loads the headers of a maildir's index the first time one
+// is needed, so searches which never look at headers don't pay for
+// decoding them.
+type lazyIndex struct {
+	root    string
+	once    sync.Once
+	headers map[string]mail.Header
+}
+
+// Header returns the indexed header of the message with unique, or nil
+// if it's not in the index.
+func (l *lazyIndex) Header(unique string) mail.Header {
+	l.once.Do(func() {
+		headers, err := loadIndexHeaders(l.root)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", l.root, err)
+		}
+		l.headers = headers
+	})
+	return l.headers[unique]
+}
+
+// loadGob decodes the gob file at path into v.  If the file doesn't
+// exist, ok is false and v is untouched.  Since gob files only hold
+// details which can be rebuilt, one which can't be decoded is treated
+// like a missing file, with a warning.  In that case, v may have been
+// partly filled and should be discarded.
+func loadGob(path string, v interface{}) (ok bool, err error) {
+	r, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "opening")
+	}
+	defer r.Close()
+
+	err = gob.NewDecoder(r).Decode(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: ignoring: %s\n", path, err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// saveGob encodes v into a gob file at path.  The file is replaced
+// atomically so concurrent readers see the old content or the new,
+// never a mixture.
+func saveGob(path string, v interface{}) error {
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "creating temp file")
+	}
+	err = gob.NewEncoder(out).Encode(v)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "writing")
+	}
+	return os.Rename(tmp, path)
+}
+
+// updateIndex brings the index of the maildir at root up to date with
+// its cur/ and new/ directories.  Only messages which are new since
+// the last update are read.  Messages which can't be parsed are left
+// out of the index, with a warning.
+func updateIndex(root string) (*index, error) {
+	idx, err := loadIndex(root)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil {
+		idx = &index{}
+	}
+	if idx.Entries == nil {
+		idx.Entries = make(map[string]*indexEntry)
+	}
+
+	// which messages exist now?
+	present := make(map[string]string)
+	for _, subdir := range []string{"cur", "new"} {
+		dir, err := os.Open(filepath.Join(root, subdir))
+		if err != nil {
+			return nil, errors.Wrap(err, "opening "+subdir)
+		}
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading "+subdir)
+		}
+		for _, name := range names {
+			p := filepath.ToSlash(filepath.Join(root, subdir, name))
+			path, err := ParsePath(p)
+			if err != nil {
+				continue
+			}
+			present[path.Unique] = p
+		}
+	}
+
+	// add messages which have arrived ...
+	changed := false
+	for unique, p := range present {
+		if _, ok := idx.Entries[unique]; ok {
+			continue
+		}
+		entry, err := indexMessage(p)
+		if err != nil {
+			// leave it out, so Find reads it like any other
+			// unindexed message
+			fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+			continue
+		}
+		idx.Entries[unique] = entry
+		changed = true
+	}
+
+	// ... and forget those which have gone
+	for unique := range idx.Entries {
+		if _, ok := present[unique]; !ok {
+			delete(idx.Entries, unique)
+			changed = true
+		}
+	}
+
+	if changed {
+		err = idx.save(root)
+		if err != nil {
+			return nil, errors.Wrap(err, "saving index")
+		}
+	}
+	return idx, nil
+}
+
+// indexMessage parses the message at path into an index entry.
+func indexMessage(path string) (*indexEntry, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	defer r.Close()
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading message")
+	}
+
+	var buf bytes.Buffer
+	for _, name := range indexedHeaders {
+		io.WriteString(&buf, decodeHeader(msg.Header.Get(name))+"\n")
+	}
+	err = extractText(&buf, msg.Header, msg.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding body")
+	}
+
+	entry := &indexEntry{
+		Header: msg.Header,
+		Terms:  searchTerms(buf.String()),
+	}
+	return entry, nil
+}
+
+// searchTerms splits text into a sorted list of distinct, lowercase
+// words.
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+
+	terms := words[:0]
+	for i, word := range words {
+		if i == 0 || word != words[i-1] {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
@@ -0,0 +1,141 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bytes"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	got := searchTerms("Re: Invoice #42 für May -- invoice attached.")
+	expected := []string{"42", "attached", "für", "invoice", "may", "re"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%q != %q", got, expected)
+	}
+
+	entry := &indexEntry{Terms: got}
+	if !entry.HasTerms([]string{"invoice", "42"}) {
+		t.Errorf("expected to find terms")
+	}
+	if entry.HasTerms([]string{"invoice", "june"}) {
+		t.Errorf("unexpected terms found")
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	header := mail.Header{
+		"Content-Type": {`multipart/mixed; boundary="b1"`},
+	}
+	body := "--b1\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"see pdf f=FCr you\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/pdf; name=\"inv.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"JVBERi0=\r\n" +
+		"--b1\r\n" +
+		"Content-Type: multipart/alternative\r\n" +
+		"\r\n" +
+		"no boundary\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=\r\n" +
+		"\r\n" +
+		"bad content type\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		strings.Repeat("x", 100000) + " end\r\n" +
+		"--b1--\r\n"
+
+	var buf bytes.Buffer
+	err := extractText(&buf, header, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "see pdf für you\n" + strings.Repeat("x", 100000) + " end\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("got %.100q, expected %.100q", got, expected)
+	}
+}
+
+func TestUpdateIndexUnreadable(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,": "Subject: one\n\nhello\n",
+		"cur/2.a:2,": "Subject: two\nthis is not a header\n\nhello\n",
+	})
+	defer os.RemoveAll(root)
+
+	idx, err := updateIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Entries) != 1 || idx.Entries["1.a"] == nil {
+		t.Errorf("expected only 1.a in the index, got %v", idx.Entries)
+	}
+}
+
+func TestIndexFind(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,":  "From: alice@example.com\nSubject: lunch\n\nsee you at noon\n",
+		"cur/2.a:2,F": "From: bob@example.com\nSubject: dinner\n\nsee you at eight\n",
+	})
+	defer os.RemoveAll(root)
+	searches := "flagged -s F .\n"
+	err := ioutil.WriteFile(filepath.Join(root, savedSearchesName), []byte(searches), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// search accepts maildirs, saved searches and messages
+	tests := map[string]string{
+		"see":          "cur/1.a:2,\ncur/2.a:2,F\n",
+		"see flagged":  "cur/2.a:2,F\n",
+		"noon 1.a:2,":  "cur/1.a:2,\n",
+		"noon flagged": "",
+	}
+	for test, expected := range tests {
+		args := strings.Fields(test)
+		for i := 1; i < len(args); i++ {
+			if args[i] == "flagged" {
+				args[i] = filepath.Join(root, args[i])
+			} else {
+				args[i] = filepath.Join(root, "cur", args[i])
+			}
+		}
+		if len(args) == 1 {
+			args = append(args, root)
+		}
+		got := captureStdout(t, func() error { return CommandSearch(args) })
+		got = strings.Replace(got, root+"/", "", -1)
+		lines := strings.Split(strings.TrimSpace(got), "\n")
+		sort.Strings(lines)
+		got = strings.TrimPrefix(strings.Join(lines, "\n")+"\n", "\n")
+		if got != expected {
+			t.Errorf("search %s: got %q, expected %q", test, got, expected)
+		}
+	}
+
+	// Find uses the indexed header, without the terms, rather than
+	// reading the message
+	if err := os.Remove(filepath.Join(root, indexName)); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(root, "cur/1.a:2,"), []byte("From: carol@example.com\n\nhi\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := &Query{Root: root}
+	query.Headers.Set("From:alice")
+	got := findNames(t, query)
+	if expected := []string{"cur/1.a:2,"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
@@ -0,0 +1,93 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// pathRecord is the JSON representation of a message's location.
+type pathRecord struct {
+	Path   string `json:"path"`
+	Unique string `json:"unique"`
+	Flags  string `json:"flags"`
+	Folder string `json:"folder"`
+	State  string `json:"state"`
+}
+
+func newPathRecord(p *Path) pathRecord {
+	return pathRecord{
+		Path:   p.String(),
+		Unique: p.Unique,
+		Flags:  p.FlagString(),
+		Folder: p.Folder(),
+		State:  p.State,
+	}
+}
+
+// headRecord is the JSON representation of a message's header, as
+// output by CommandHead.
+type headRecord struct {
+	pathRecord
+
+	// Size is the message's size in bytes, if requested.
+	Size interface{} `json:"size,omitempty"`
+
+	// Modified is the message file's mtime, if requested.
+	Modified interface{} `json:"mtime,omitempty"`
+
+	// Delivered is the delivery time from the message's unique, if
+	// requested.
+	Delivered interface{} `json:"delivered,omitempty"`
+
+	// Headers maps each requested header name to its value.
+	Headers map[string]interface{} `json:"headers,omitempty"`
+}
+
+// countRecord is the JSON representation of a folder's total, as
+// output by CommandCount.
+type countRecord struct {
+	Folder string `json:"folder"`
+	Count  int    `json:"count"`
+}
+
+// addressRecord is the JSON representation of an email address.
+type addressRecord struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// writeJSON writes v to w as a single line of JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// The following functions are JSON equivalents of the column types
+// used by CommandHead.  Values which can't be parsed become null.
+
+func jsonAddress(m *headView, h, v string) interface{} {
+	if v == "" {
+		return []addressRecord{}
+	}
+	addresses, err := parseAddressList(m.field(h))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid address: %q\n", v)
+		return nil
+	}
+	records := make([]addressRecord, len(addresses))
+	for i, address := range addresses {
+		records[i] = addressRecord{Name: address.Name, Email: address.Address}
+	}
+	return records
+}
+
+func jsonSize(m *headView, h, v string) interface{} {
+	size, err := (&candidate{path: m.path}).Size()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid size: %s\n", err)
+		return nil
+	}
+	return size
+}
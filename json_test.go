@@ -0,0 +1,88 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeadJSON(t *testing.T) {
+	message := "From: Alice <alice@example.com>\n" +
+		"To: bob@example.com, \"Carol, C.\" <carol@example.com>\n" +
+		"Cc: not an address <\n" +
+		"Date: not a date\n" +
+		"Subject: tab\there =?utf-8?q?and_a=0Anewline?=\n" +
+		"Received: from a\n" +
+		"Received: from b\n" +
+		"\n" +
+		"hi\n"
+	root := testMaildir(t, map[string]string{"cur/1500000000.a:2,S": message})
+	defer os.RemoveAll(root)
+	name := filepath.Join(root, "cur/1500000000.a:2,S")
+	mtime := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args     string
+		expected string // JSON keys other than the path record's
+	}{
+		{
+			args:     "-a From -a To",
+			expected: `{"headers": {"From": [{"name": "Alice", "email": "alice@example.com"}], "To": [{"name": "", "email": "bob@example.com"}, {"name": "Carol, C.", "email": "carol@example.com"}]}}`,
+		},
+		{
+			args:     "-a Cc -t Date -a Bcc",
+			expected: `{"headers": {"Cc": null, "Date": null, "Bcc": []}}`,
+		},
+		{
+			args:     "-S -m -D",
+			expected: fmt.Sprintf(`{"size": %d, "mtime": "2018-05-01T10:00:00Z", "delivered": "2017-07-14T02:40:00Z"}`, len(message)),
+		},
+		{
+			args:     "-s Subject -A Received -A X-Missing",
+			expected: `{"headers": {"Subject": "tab\there and a\nnewline", "Received": ["from a", "from b"], "X-Missing": []}}`,
+		},
+	}
+	for _, test := range tests {
+		args := append(strings.Fields(test.args), "-json", name)
+		got := captureStdout(t, func() error { return CommandHead(args) })
+
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(got), &record); err != nil {
+			t.Errorf("%s: %s: %q", test.args, err, got)
+			continue
+		}
+		paths := map[string]interface{}{
+			"path":   name,
+			"unique": "1500000000.a",
+			"flags":  "S",
+			"folder": root,
+			"state":  "cur",
+		}
+		for key, value := range paths {
+			if record[key] != value {
+				t.Errorf("%s: %s is %q, expected %q", test.args, key, record[key], value)
+			}
+			delete(record, key)
+		}
+		var expected map[string]interface{}
+		if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(record, expected) {
+			t.Errorf("%s: got %v, expected %v", test.args, record, expected)
+		}
+	}
+
+	// a header can only be one key of a record
+	err := CommandHead([]string{"-s", "Subject", "-b", "Subject", "-json", name})
+	if err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("duplicate column: got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"flag"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var listTargetRx = regexp.MustCompile(`<([^<>]*)>`)
+
+// listTargets extracts the bracketed values from a mailing list header
+// as described by RFC 2369 and RFC 2919.  For List-Unsubscribe and
+// friends, those are URLs.  For List-Id, it's the list's identifier.
+// If scheme is not empty, only URLs with one of those schemes are kept.
+func listTargets(v string, schemes ...string) []string {
+	var targets []string
+	for _, match := range listTargetRx.FindAllStringSubmatch(v, -1) {
+		// whitespace inside the brackets is meaningless
+		target := strings.Join(strings.Fields(match[1]), "")
+		if target == "" || !hasScheme(target, schemes) {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func hasScheme(target string, schemes []string) bool {
+	if len(schemes) == 0 {
+		return true
+	}
+	lower := strings.ToLower(target)
+	for _, scheme := range schemes {
+		if strings.HasPrefix(lower, scheme+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// listID returns a message's list identifier and description from its
+// List-Id header.
+func listID(header mail.Header) (id, description string) {
+	v := decodeHeader(header.Get("List-Id"))
+	targets := listTargets(v)
+	if len(targets) == 0 {
+		return strings.TrimSpace(v), ""
+	}
+	i := strings.Index(v, "<")
+	description = strings.Trim(strings.TrimSpace(v[:i]), `"`)
+	return targets[0], description
+}
+
+func typeListTargets(m *headView, h, v string) string {
+	return strings.Join(listTargets(v), ", ")
+}
+
+func typeListMailto(m *headView, h, v string) string {
+	return strings.Join(listTargets(v, "mailto"), ", ")
+}
+
+func typeListWeb(m *headView, h, v string) string {
+	return strings.Join(listTargets(v, "http", "https"), ", ")
+}
+
+// The JSON equivalents of the list column types.
+
+func jsonListTargets(m *headView, h, v string) interface{} {
+	return nonNil(listTargets(v))
+}
+
+func jsonListMailto(m *headView, h, v string) interface{} {
+	return nonNil(listTargets(v, "mailto"))
+}
+
+func jsonListWeb(m *headView, h, v string) interface{} {
+	return nonNil(listTargets(v, "http", "https"))
+}
+
+// nonNil makes sure a list is output as [] rather than null.
+func nonNil(ss []string) []string {
+	if ss == nil {
+		return []string{}
+	}
+	return ss
+}
+
+// mailingList summarizes the messages from one mailing list.
+type mailingList struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	Count       int      `json:"count"`
+	Unsubscribe []string `json:"unsubscribe"`
+	Post        []string `json:"post"`
+	Archive     []string `json:"archive"`
+
+	// newest is when the newest message in the summary was received.
+	newest time.Time
+}
+
+// add includes a message, received at t, in the summary.  Details from
+// the newest message are kept, since lists sometimes change them.
+func (list *mailingList) add(header mail.Header, t time.Time, description string) {
+	list.Count++
+	if list.Count > 1 && t.Before(list.newest) {
+		return
+	}
+	list.newest = t
+	if description != "" {
+		list.Description = description
+	}
+	if targets := listTargets(header.Get("List-Unsubscribe")); len(targets) > 0 {
+		list.Unsubscribe = targets
+	}
+	if targets := listTargets(header.Get("List-Post")); len(targets) > 0 {
+		list.Post = targets
+	}
+	if targets := listTargets(header.Get("List-Archive")); len(targets) > 0 {
+		list.Archive = targets
+	}
+}
+
+// byCount sorts lists with the most messages first, then by ID.
+type byCount []*mailingList
+
+func (ls byCount) Len() int      { return len(ls) }
+func (ls byCount) Swap(i, j int) { ls[i], ls[j] = ls[j], ls[i] }
+func (ls byCount) Less(i, j int) bool {
+	if ls[i].Count != ls[j].Count {
+		return ls[i].Count > ls[j].Count
+	}
+	return ls[i].ID < ls[j].ID
+}
+
+// CommandLists summarizes the messages in folders by mailing list.  For
+// example,
+//
+//    mailz lists -c T inbox
+//
+// outputs each list's identifier, message count and unsubscribe URLs,
+// most prolific list first.  Messages without a List-Id, but with a
+// List-Unsubscribe header, are grouped by sender.
+func CommandLists(args []string) error {
+	fs := flag.NewFlagSet("lists", flag.ContinueOnError)
+	q := &Query{}
+	allowQueryArguments(fs, q)
+	outputJSON := fs.Bool("json", false, `Output a JSON record for each list`)
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, "parsing command line flags")
+	}
+	folders := fs.Args()
+	if len(folders) == 0 {
+		folders = []string{"."}
+	}
+
+	lists := make(map[string]*mailingList)
+	for _, folder := range folders {
+		q.Root = folder
+		err := Find(q, func(path *Path) {
+			c := &candidate{path: path}
+			var t time.Time
+			header, err := c.Header()
+			if err == nil {
+				t, err = c.Time()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+				return
+			}
+			id, description := listID(header)
+			if id == "" && header.Get("List-Unsubscribe") != "" {
+				from, err := parseAddressList(header.Get("From"))
+				if err == nil && len(from) > 0 {
+					id = from[0].Address
+				}
+			}
+			if id == "" {
+				return
+			}
+
+			list, ok := lists[id]
+			if !ok {
+				list = &mailingList{ID: id}
+				lists[id] = list
+			}
+			list.add(header, t, description)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sorted := make([]*mailingList, 0, len(lists))
+	for _, list := range lists {
+		sorted = append(sorted, list)
+	}
+	sort.Sort(byCount(sorted))
+	for _, list := range sorted {
+		if *outputJSON {
+			list.Unsubscribe = nonNil(list.Unsubscribe)
+			list.Post = nonNil(list.Post)
+			list.Archive = nonNil(list.Archive)
+			err := writeJSON(os.Stdout, list)
+			if err != nil {
+				return errors.Wrap(err, "writing JSON")
+			}
+			continue
+		}
+		fmt.Printf("%s\t%d\t%s\n", list.ID, list.Count, strings.Join(list.Unsubscribe, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"net/mail"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestListTargets(t *testing.T) {
+	tests := []struct {
+		value    string
+		schemes  []string
+		expected []string
+	}{
+		{`<mailto:unsub@example.com>, <https://example.com/unsub>`, nil, []string{"mailto:unsub@example.com", "https://example.com/unsub"}},
+		{`<mailto:unsub@example.com>, <https://example.com/unsub>`, []string{"mailto"}, []string{"mailto:unsub@example.com"}},
+		{`<mailto:unsub@example.com>, <https://example.com/unsub>`, []string{"http", "https"}, []string{"https://example.com/unsub"}},
+		{`<ftp://ftp.example.com/list.txt> (FTP), <mailto:list@example.com?subject=help>`, []string{"mailto"}, []string{"mailto:list@example.com?subject=help"}},
+		{`<http://www.example.com/long/
+		  path>`, nil, []string{"http://www.example.com/long/path"}},
+		{`NO (posting not allowed)`, nil, nil},
+		{``, nil, nil},
+	}
+	for _, test := range tests {
+		got := listTargets(test.value, test.schemes...)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q %v: got %q, expected %q", test.value, test.schemes, got, test.expected)
+		}
+	}
+}
+
+func TestListID(t *testing.T) {
+	tests := []struct {
+		value, id, description string
+	}{
+		{`Go Nuts <golang-nuts.googlegroups.com>`, `golang-nuts.googlegroups.com`, `Go Nuts`},
+		{`"Weekly News" <news.example.com>`, `news.example.com`, `Weekly News`},
+		{`<list.example.org>`, `list.example.org`, ``},
+		{`list.example.org`, `list.example.org`, ``},
+		{``, ``, ``},
+	}
+	for _, test := range tests {
+		id, description := listID(mail.Header{"List-Id": {test.value}})
+		if id != test.id || description != test.description {
+			t.Errorf("%q: got %q %q, expected %q %q", test.value, id, description, test.id, test.description)
+		}
+	}
+}
+
+func TestCommandLists(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,": "From: news@example.com\nList-Id: <news.example.com>\nList-Unsubscribe: <mailto:unsub@example.com>\nDate: Mon, 2 Jan 2006 15:04:05 +0000\n\nhi\n",
+		"cur/2.a:2,": "From: promo@example.com\nList-Unsubscribe: <https://example.com/unsub>\nDate: Mon, 2 Jan 2006 15:04:05 +0000\n\nhi\n",
+		"cur/3.a:2,": "From: undisclosed-recipients:;\nList-Unsubscribe: <https://example.com/other>\nDate: Mon, 2 Jan 2006 15:04:05 +0000\n\nhi\n",
+		"cur/4.a:2,": "From: friend@example.com\nDate: Mon, 2 Jan 2006 15:04:05 +0000\n\nhi\n",
+	})
+	defer os.RemoveAll(root)
+
+	got := captureStdout(t, func() error { return CommandLists([]string{root}) })
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	sort.Strings(lines)
+	expected := []string{
+		"news.example.com\t1\tmailto:unsub@example.com",
+		"promo@example.com\t1\thttps://example.com/unsub",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got %q, expected %q", lines, expected)
+	}
+}
@@ -120,3 +120,31 @@ func (md *Maildir) HasAnyMessages() bool {
 	}
 	return false
 }
+
+// MessageFolders returns the paths of this maildir and all its
+// folders which contain messages directly.  Parents come before their
+// folders.
+func (md *Maildir) MessageFolders() []string {
+	var paths []string
+	if md.HasMessages {
+		paths = append(paths, md.Path)
+	}
+	for _, folder := range md.Folders {
+		paths = append(paths, folder.MessageFolders()...)
+	}
+	return paths
+}
+
+// maildirs skims the directory tree at root and returns the path of
+// every maildir it contains.
+func maildirs(root string) ([]string, error) {
+	md := &Maildir{Path: root}
+	err := md.Skim()
+	if err == errNoMessages {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return md.MessageFolders(), nil
+}
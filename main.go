@@ -65,16 +65,32 @@ func Dispatch(args []string) error {
 		err = CommandCount(args[1:])
 	case "cur":
 		err = CommandCur(args[1:])
+	case "grep":
+		err = CommandGrep(args[1:])
 	case "head":
 		err = CommandHead(args[1:])
+	case "headers":
+		err = CommandHeaders(args[1:])
+	case "dedupe":
+		err = CommandDedupe(args[1:])
 	case "find":
 		err = CommandFind(args[1:])
 	case "flags":
 		err = CommandFlags(args[1:])
+	case "index":
+		err = CommandIndex(args[1:])
+	case "lists":
+		err = CommandLists(args[1:])
 	case "move":
 		err = CommandMove(args[1:])
+	case "mute":
+		err = CommandMute(args[1:])
 	case "resolve":
 		err = CommandResolve(args[1:])
+	case "search":
+		err = CommandSearch(args[1:])
+	case "thread":
+		err = CommandThread(args[1:])
 	case "unique":
 		err = CommandUnique(args[1:])
 	default:
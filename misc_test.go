@@ -1,5 +1,11 @@
 package mailz // import "github.com/mndrix/mailz"
-import "testing"
+import (
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestUnique(t *testing.T) {
 	tests := [][]string{
@@ -40,3 +46,292 @@ func TestGenerateUnique(t *testing.T) {
 		ok(u)
 	}
 }
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2018, 5, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		arg      string
+		expected time.Time
+	}{
+		{`36h`, now.Add(-36 * time.Hour)},
+		{`7d`, now.AddDate(0, 0, -7)},
+		{`2w`, now.AddDate(0, 0, -14)},
+		{`2018-05-01T08:00:00Z`, time.Date(2018, 5, 1, 8, 0, 0, 0, time.UTC)},
+		{`2018-05-01`, time.Date(2018, 5, 1, 0, 0, 0, 0, time.Local)},
+	}
+
+	for _, test := range tests {
+		got, err := parseTimeBound(test.arg, now)
+		if err != nil {
+			t.Errorf("%q: %s", test.arg, err)
+			continue
+		}
+		if !got.Equal(test.expected) {
+			t.Errorf("%q: %s != %s", test.arg, got, test.expected)
+		}
+	}
+
+	if _, err := parseTimeBound(`yesterday`, now); err == nil {
+		t.Errorf("expected an error for an invalid time")
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{`flagged -s F -c S inbox`, []string{"flagged", "-s", "F", "-c", "S", "inbox"}},
+		{`invoices  -q "subject:invoice and not flag:T"	.`, []string{"invoices", "-q", "subject:invoice and not flag:T", "."}},
+		{`quoted -q 'from:"Alice Smith"' ''`, []string{"quoted", "-q", `from:"Alice Smith"`, ""}},
+	}
+
+	for _, test := range tests {
+		got, err := splitArgs(test.line)
+		if err != nil {
+			t.Errorf("%q: %s", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q: %q != %q", test.line, got, test.expected)
+		}
+	}
+
+	if _, err := splitArgs(`open "quote`); err == nil {
+		t.Errorf("expected an error for an unterminated quote")
+	}
+}
+
+func TestMergeFlags(t *testing.T) {
+	tests := []struct {
+		paths    []string
+		expected string
+	}{
+		{[]string{`cur/a:2,S`, `new/b:2,F`}, `FS`},
+		{[]string{`cur/a:2,S`, `cur/b:2,RT`}, `RS`},
+		{[]string{`cur/a:2,ST`, `cur/b:2,T`}, `ST`},
+	}
+
+	for _, test := range tests {
+		var copies []*Path
+		for _, p := range test.paths {
+			path, err := ParsePath(p)
+			if err != nil {
+				t.Fatalf("can't parse %q: %s", p, err)
+			}
+			copies = append(copies, path)
+		}
+		merged := &Path{Flags: mergeFlags(copies)}
+		if got := merged.FlagString(); got != test.expected {
+			t.Errorf("%q: %q != %q", test.paths, got, test.expected)
+		}
+	}
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	tests := []struct {
+		subject  string
+		expected string
+		isReply  bool
+	}{
+		{`Hello`, `Hello`, false},
+		{`Re: Hello`, `Hello`, true},
+		{`RE: re:Hello`, `Hello`, true},
+		{`Fwd: Re[2]: Hello   there`, `Hello there`, true},
+		{`AW: WG: Hello`, `Hello`, true},
+		{`SV: Antw: Hello`, `Hello`, true},
+		{`[golang-nuts] Re: [ANN] Hello`, `Hello`, true},
+		{`[list] status`, `status`, false},
+		{`[ANN]`, `[ANN]`, false},
+		{`Hello (fwd)`, `Hello`, true},
+		{`Regarding: the plan`, `Regarding: the plan`, false},
+	}
+
+	for _, test := range tests {
+		got, isReply := parseSubject(test.subject)
+		if got != test.expected || isReply != test.isReply {
+			t.Errorf("%q: got (%q, %t), expected (%q, %t)", test.subject, got, isReply, test.expected, test.isReply)
+		}
+	}
+}
+
+func TestHeadTemplate(t *testing.T) {
+	path, err := ParsePath("inbox/cur/1525290638.1_1.x1:2,SR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := mail.Header{
+		"From":    {`=?utf-8?q?J=C3=BCrgen?= <jurgen@example.com>`},
+		"To":      {`alice@example.com, Bob <bob@example.com>`},
+		"Date":    {`Wed, 2 May 2018 10:00:00 +0200`},
+		"Subject": {`Re: [list] Lunch`},
+	}
+	tests := map[string]string{
+		`{{.Flags}} {{.Unique}} {{.Folder}}`:                           `RS 1525290638.1_1.x1 inbox`,
+		`{{.Name "From"}}: {{.BaseSubject}}`:                           `Jürgen: Lunch`,
+		`{{range .Addresses "To"}}{{.Address}};{{end}}`:                `alice@example.com;bob@example.com;`,
+		`{{(.Time "Date").UTC.Format "2006-01-02 15:04"}}`:             `2018-05-02 08:00`,
+		`[{{pad 6 (.Name "To")}}] [{{pad -4 "x"}}] {{trunc 3 "abcd"}}`: `[alice@example.com] [   x] abc`,
+	}
+	for text, expected := range tests {
+		tmpl, err := parseHeadTemplate(text)
+		if err != nil {
+			t.Errorf("%s: %s", text, err)
+			continue
+		}
+		got, err := formatHeadTemplate(tmpl, path, header)
+		if err != nil {
+			t.Errorf("%s: %s", text, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("%s: got %q, expected %q", text, got, expected)
+		}
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	path, err := ParsePath("cur/1525290638.1_1.x1:2,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &headView{
+		path: path,
+		header: mail.Header{
+			"Date":     {`Wed, 2 May 2018 10:00:00 +0200`},
+			"Received": {`from x by y; bogus`},
+		},
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Date(2018, 5, 2, 11, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		format   timeFormat
+		source   string
+		expected string
+	}{
+		{defaultTimeFormat, "Date", "2018-05-02T08:00:00Z"},
+		{defaultTimeFormat, "delivered", "2018-05-02T19:50:38Z"},
+		{timeFormat{Layout: "15:04 -0700"}, "Date", "10:00 +0200"},
+		{timeFormat{Layout: "15:04", Location: berlin}, "Date", "10:00"},
+		{timeFormat{Layout: "ago", Location: time.UTC}, "Date", "3h ago"},
+		{timeFormat{Layout: "unix"}, "Date", "1525248000"},
+		{timeFormat{Layout: time.RFC3339, Location: time.UTC, Fallback: []string{"Date"}}, "Received", "2018-05-02T08:00:00Z"},
+		{timeFormat{Layout: time.RFC3339, Location: time.UTC, Fallback: []string{"X-Missing", "delivered"}}, "Received", "2018-05-02T19:50:38Z"},
+	}
+	for _, test := range tests {
+		got, err := test.format.resolve(m, test.source)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		s := test.format.format(got, now)
+		if s != test.expected {
+			t.Errorf("%s %+v: got %q, expected %q", test.source, test.format, s, test.expected)
+		}
+	}
+
+	// without a fallback, there's no time
+	_, err = defaultTimeFormat.resolve(m, "Received")
+	if err == nil {
+		t.Errorf("expected an error for a bogus Received header")
+	}
+}
+
+func TestFormatAgo(t *testing.T) {
+	tests := map[time.Duration]string{
+		45 * time.Second:  "45s ago",
+		5 * time.Minute:   "5m ago",
+		47 * time.Hour:    "47h ago",
+		72 * time.Hour:    "3d ago",
+		-90 * time.Minute: "in 1h",
+	}
+	for d, expected := range tests {
+		if got := formatAgo(d); got != expected {
+			t.Errorf("%s: got %q, expected %q", d, got, expected)
+		}
+	}
+}
+
+func TestHeaderField(t *testing.T) {
+	header := mail.Header{
+		"Received": {"from b by c; Wed, 2 May 2018 10:00:05 +0000", "from a by b; Wed, 2 May 2018 10:00:01 +0000"},
+		"Subject":  {"Hello"},
+	}
+	tests := map[string]string{
+		"Received":     "from b by c; Wed, 2 May 2018 10:00:05 +0000",
+		"received[0]":  "from b by c; Wed, 2 May 2018 10:00:05 +0000",
+		"Received[1]":  "from a by b; Wed, 2 May 2018 10:00:01 +0000",
+		"Received[-1]": "from a by b; Wed, 2 May 2018 10:00:01 +0000",
+		"Received[-2]": "from b by c; Wed, 2 May 2018 10:00:05 +0000",
+		"Received[2]":  "",
+		"Received[-3]": "",
+		"Subject[0]":   "Hello",
+		"X-Missing[0]": "",
+	}
+	for name, expected := range tests {
+		if got := headerField(header, name); got != expected {
+			t.Errorf("%s: got %q, expected %q", name, got, expected)
+		}
+	}
+
+	got, err := parseHeaderTime("Received[-1]", headerField(header, "Received[-1]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2018, 5, 2, 10, 0, 1, 0, time.UTC); !got.Equal(expected) {
+		t.Errorf("origin hop: got %s, expected %s", got, expected)
+	}
+}
+
+func TestReadRawHeaders(t *testing.T) {
+	message := "Subject: =?utf-8?q?caf=C3=A9?=\r\n" +
+		"Received: from a\r\n" +
+		"\tby b;\r\n" +
+		"  Wed, 2 May 2018 10:00:01 +0000\r\n" +
+		"From: alice@example.com\r\n" +
+		"X-Spam: yes\r\n" +
+		"\r\n" +
+		"To: not a header\r\n"
+	headers, err := readRawHeaders(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []rawHeader{
+		{"Subject", "=?utf-8?q?caf=C3=A9?="},
+		{"Received", "from a by b; Wed, 2 May 2018 10:00:01 +0000"},
+		{"From", "alice@example.com"},
+		{"X-Spam", "yes"},
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Fatalf("got %q, expected %q", headers, expected)
+	}
+
+	names := func(hs []rawHeader) string {
+		var ns []string
+		for _, h := range hs {
+			ns = append(ns, h.Name)
+		}
+		return strings.Join(ns, ",")
+	}
+	tests := []struct {
+		weeding   headerWeeding
+		keepOrder bool
+		expected  string
+	}{
+		{headerWeeding{}, false, "Subject,Received,From,X-Spam"},
+		{headerWeeding{Ignore: []string{"x-", "received"}}, false, "Subject,From"},
+		{headerWeeding{Ignore: []string{"*"}, Unignore: []string{"from:", "subject"}}, false, "From,Subject"},
+		{headerWeeding{Ignore: []string{"*"}, Unignore: []string{"from:", "subject"}}, true, "Subject,From"},
+		{headerWeeding{Unignore: []string{"x-"}}, false, "X-Spam,Subject,Received,From"},
+	}
+	for _, test := range tests {
+		got := names(test.weeding.Weed(headers, test.keepOrder))
+		if got != test.expected {
+			t.Errorf("%+v: got %s, expected %s", test.weeding, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// muteListName is the file, inside a maildir, which lists the
+// Message-IDs of messages in muted conversations, one per line.  A
+// message which refers to any of them is muted too.
+const muteListName = ".mailz-muted"
+
+// threadRootID returns the Message-ID of the first message in the
+// conversation of a message with this header.  That's the oldest
+// reference, if there are any, or the message itself.
+func threadRootID(header mail.Header) string {
+	if ids := messageIDs(header.Get("References")); len(ids) > 0 {
+		return ids[0]
+	}
+	if ids := messageIDs(header.Get("In-Reply-To")); len(ids) > 0 {
+		return ids[0]
+	}
+	return normalizeMessageID(header.Get("Message-Id"))
+}
+
+// loadMuteList reads the muted conversations of the maildir at root.
+// A missing list has no conversations.
+func loadMuteList(root string) (map[string]bool, error) {
+	muted := make(map[string]bool)
+	f, err := os.Open(filepath.Join(root, muteListName))
+	if os.IsNotExist(err) {
+		return muted, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "opening mute list")
+	}
+	defer f.Close()
+
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		if id := normalizeMessageID(lines.Text()); id != "" {
+			muted[id] = true
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading mute list")
+	}
+	return muted, nil
+}
+
+// mute adds Message-IDs to the mute list of the maildir at root.
+func mute(root string, ids ...string) error {
+	muted, err := loadMuteList(root)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, id := range ids {
+		if id != "" && !muted[id] {
+			muted[id] = true
+			fmt.Fprintf(&buf, "<%s>\n", id)
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(filepath.Join(root, muteListName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return errors.Wrap(err, "opening mute list")
+	}
+	_, err = buf.WriteTo(f)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	return errors.Wrap(err, "writing mute list")
+}
+
+// muteLists decides whether messages belong to muted conversations,
+// loading each maildir's mute list once.  It's safe for concurrent
+// use.
+type muteLists struct {
+	mu    sync.Mutex
+	lists map[string]map[string]bool
+}
+
+// list returns the mute list of folder.  The caller must hold ml.mu.
+func (ml *muteLists) list(folder string) (map[string]bool, error) {
+	muted, ok := ml.lists[folder]
+	if !ok {
+		var err error
+		muted, err = loadMuteList(folder)
+		if err != nil {
+			return nil, err
+		}
+		if ml.lists == nil {
+			ml.lists = make(map[string]map[string]bool)
+		}
+		ml.lists[folder] = muted
+	}
+	return muted, nil
+}
+
+// IsMuted returns true if the message, or any message it refers to,
+// is in a muted conversation.
+func (ml *muteLists) IsMuted(c *candidate) (bool, error) {
+	ml.mu.Lock()
+	muted, err := ml.list(c.path.Folder())
+	n := len(muted)
+	ml.mu.Unlock()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	header, err := c.Header()
+	if err != nil {
+		return false, err
+	}
+	ids := []string{normalizeMessageID(header.Get("Message-Id"))}
+	ids = append(ids, messageIDs(header.Get("References"))...)
+	ids = append(ids, messageIDs(header.Get("In-Reply-To"))...)
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	for _, id := range ids {
+		if muted[id] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Mute adds the message to the mute list of its maildir, so replies
+// to it are muted even if they don't refer to the conversation's
+// earlier messages.
+func (ml *muteLists) Mute(c *candidate) error {
+	header, err := c.Header()
+	if err != nil {
+		return err
+	}
+	id := normalizeMessageID(header.Get("Message-Id"))
+	if id == "" {
+		return nil
+	}
+
+	folder := c.path.Folder()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	muted, err := ml.list(folder)
+	if err != nil {
+		return err
+	}
+	muted[id] = true
+	return mute(folder, id)
+}
+
+func mutedPredicate() predicate {
+	ml := &muteLists{}
+	return predicateFunc(ml.IsMuted)
+}
+
+// muteRef mutes the conversation containing the message at ref and
+// returns the ID of the conversation's first message.  The IDs of the
+// conversation's other messages in the maildir are recorded too, so
+// replies to any of them are muted.
+func muteRef(ref string) (string, error) {
+	resolved, err := Resolve(ref)
+	if err != nil {
+		return "", errors.Wrap(err, "resolve ref")
+	}
+	path, err := ParsePath(resolved)
+	if err != nil {
+		return "", errors.Wrap(err, "parse path")
+	}
+	header, err := readHeader(resolved)
+	if err != nil {
+		return "", err
+	}
+	id := threadRootID(header)
+	if strings.TrimSpace(id) == "" {
+		return "", errors.New("message has no Message-ID")
+	}
+	ids := []string{id}
+	thread, err := expandThreads([]*Path{path}, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "threading")
+	}
+	for _, p := range thread {
+		header, err := readHeader(p.String())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+			continue
+		}
+		ids = append(ids, normalizeMessageID(header.Get("Message-Id")))
+	}
+	return id, mute(path.Folder(), ids...)
+}
@@ -0,0 +1,91 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCurMuted(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,S": "Message-ID: <1@a>\nSubject: noisy\n\nhi\n",
+		"new/2.a:2,":  "Message-ID: <2@a>\nIn-Reply-To: <1@a>\nSubject: Re: noisy\n\nhi\n",
+		"new/3.a:2,":  "Message-ID: <3@a>\nReferences: <1@a> <2@a>\nSubject: Re: noisy\n\nhi\n",
+		"new/4.a:2,":  "Message-ID: <4@a>\nSubject: quiet\n\nhi\n",
+		"new/5.a:2,":  "Message-ID: <5@a>\nthis is not a header\n\nhi\n",
+		"new/0.a:2,":  "Message-ID: <0@a>\nIn-Reply-To: <3@a>\nSubject: Re: noisy\n\nhi\n",
+	})
+	defer os.RemoveAll(root)
+	err := ioutil.WriteFile(filepath.Join(root, muteListName), []byte("<1@a>\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CommandCur([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := maildirNames(t, root)
+	expected := []string{
+		"cur/0.a:2,ST",
+		"cur/1.a:2,S",
+		"cur/2.a:2,ST",
+		"cur/3.a:2,ST",
+		"cur/4.a:2,",
+		"cur/5.a:2,",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("got %q, expected %q", names, expected)
+	}
+}
+
+func TestMute(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,S": "Message-ID: <1@a>\nSubject: noisy\n\nhi\n",
+		"cur/2.a:2,S": "Message-ID: <2@a>\nIn-Reply-To: <1@a>\nSubject: Re: noisy\n\nhi\n",
+		"cur/3.a:2,S": "Message-ID: <3@a>\nSubject: quiet\n\nhi\n",
+	})
+	defer os.RemoveAll(root)
+
+	// muting any message in the conversation records all of them
+	err := CommandMute([]string{filepath.Join(root, "cur/2.a:2,S")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	muted, err := loadMuteList(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]bool{"1@a": true, "2@a": true}; !reflect.DeepEqual(muted, expected) {
+		t.Errorf("got %v, expected %v", muted, expected)
+	}
+
+	// so a later reply to a message other than the first is muted
+	arrivals := map[string]string{
+		"new/4.a:2,": "Message-ID: <4@a>\nIn-Reply-To: <2@a>\nSubject: Re: noisy\n\nhi\n",
+		"new/5.a:2,": "Message-ID: <5@a>\nIn-Reply-To: <3@a>\nSubject: Re: quiet\n\nhi\n",
+	}
+	for name, content := range arrivals {
+		err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = CommandCur([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := maildirNames(t, root)
+	expected := []string{
+		"cur/1.a:2,S",
+		"cur/2.a:2,S",
+		"cur/3.a:2,S",
+		"cur/4.a:2,ST",
+		"cur/5.a:2,",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("got %q, expected %q", names, expected)
+	}
+}
@@ -0,0 +1,36 @@
+package mailz // import "github.com/mndrix/mailz"
+
+// inOrder calls work(i) for each i in [0,n) using up to jobs
+// goroutines at once.  emit(i) is called from the calling goroutine,
+// in ascending order of i, as soon as work(i) has finished.  That
+// lets slow work run concurrently while output stays in order.
+func inOrder(jobs, n int, work func(int), emit func(int)) {
+	if jobs <= 1 {
+		for i := 0; i < n; i++ {
+			work(i)
+			emit(i)
+		}
+		return
+	}
+
+	done := make([]chan struct{}, n)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	go func() {
+		sem := make(chan struct{}, jobs)
+		for i := 0; i < n; i++ {
+			sem <- struct{}{}
+			go func(i int) {
+				work(i)
+				close(done[i])
+				<-sem
+			}(i)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		<-done[i]
+		emit(i)
+	}
+}
@@ -0,0 +1,30 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"testing"
+	"time"
+)
+
+func TestInOrder(t *testing.T) {
+	for _, jobs := range []int{1, 4, 100} {
+		const n = 50
+		results := make([]int, n)
+		var emitted []int
+		inOrder(jobs, n, func(i int) {
+			// later items finish first
+			time.Sleep(time.Duration(n-i) * 10 * time.Microsecond)
+			results[i] = i * i
+		}, func(i int) {
+			emitted = append(emitted, results[i])
+		})
+
+		if len(emitted) != n {
+			t.Errorf("jobs=%d: emitted %d items", jobs, len(emitted))
+			continue
+		}
+		for i, got := range emitted {
+			if got != i*i {
+				t.Errorf("jobs=%d: item %d is %d", jobs, i, got)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -80,7 +81,31 @@ func (p *Path) IsSet(flag rune) bool {
 	return p.Flags[flag]
 }
 
+// Folder returns the maildir containing this message.
+func (p *Path) Folder() string {
+	if p.Prefix == "" {
+		return "."
+	}
+	return p.Prefix
+}
+
 // Cur changes the path from new/ to cur/
 func (p *Path) Cur() {
 	p.State = "cur"
 }
+
+// Size returns the message size recorded in the unique by a ",S="
+// field (see Maildir++).  ok is false if there's no such field.
+func (p *Path) Size() (size int64, ok bool) {
+	fields := strings.Split(p.Unique, ",")
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "S=") {
+			continue
+		}
+		n, err := strconv.ParseInt(field[2:], 10, 64)
+		if err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
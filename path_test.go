@@ -44,3 +44,30 @@ func TestCur(t *testing.T) {
 		}
 	}
 }
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		path string
+		size int64
+		ok   bool
+	}{
+		{`cur/1525290638.35577_1.x1,U=30:2,`, 0, false},
+		{`cur/1525290638.35577_1.x1,S=1234:2,S`, 1234, true},
+		{`cur/1525290638.35577_1.x1,S=1234,W=1260:2,`, 1234, true},
+		{`cur/1525290638.35577_1.x1,S=big:2,`, 0, false},
+		{`new/foo:2,`, 0, false},
+	}
+
+	for _, test := range tests {
+		path, err := ParsePath(test.path)
+		if err != nil {
+			t.Errorf("can't parse %q: %s", test.path, err)
+			continue
+		}
+
+		size, ok := path.Size()
+		if size != test.size || ok != test.ok {
+			t.Errorf("%q: got (%d, %t), expected (%d, %t)", test.path, size, ok, test.size, test.ok)
+		}
+	}
+}
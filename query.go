@@ -0,0 +1,526 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// predicate is a condition which a message might meet.
+type predicate interface {
+	match(c *candidate) (bool, error)
+}
+
+// predicateFunc adapts an ordinary function into a predicate.
+type predicateFunc func(*candidate) (bool, error)
+
+func (f predicateFunc) match(c *candidate) (bool, error) {
+	return f(c)
+}
+
+// andPredicate matches when all its predicates match.  An empty
+// andPredicate matches every message.
+type andPredicate []predicate
+
+func (ps andPredicate) match(c *candidate) (bool, error) {
+	for _, p := range ps {
+		ok, err := p.match(c)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// orPredicate matches when any of its predicates match.
+type orPredicate []predicate
+
+func (ps orPredicate) match(c *candidate) (bool, error) {
+	for _, p := range ps {
+		ok, err := p.match(c)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// notPredicate matches when its predicate doesn't.
+type notPredicate struct {
+	predicate
+}
+
+func (p notPredicate) match(c *candidate) (bool, error) {
+	ok, err := p.predicate.match(c)
+	return !ok && err == nil, err
+}
+
+// predicate combines all of the query's conditions into one.
+func (q *Query) predicate() predicate {
+	var ps andPredicate
+	if len(q.FlagClear) > 0 || len(q.FlagSet) > 0 {
+		ps = append(ps, flagPredicate(q.FlagSet, q.FlagClear))
+	}
+	for _, m := range q.Headers {
+		ps = append(ps, headerPredicate(m))
+	}
+	if !q.Since.IsZero() || !q.Before.IsZero() {
+		ps = append(ps, timePredicate(q.Since.Time, q.Before.Time))
+	}
+	if q.Body.Regexp != nil {
+		ps = append(ps, bodyPredicate(q.Body))
+	}
+	if q.Larger.IsSet {
+		ps = append(ps, sizePredicate(">", q.Larger.Bytes))
+	}
+	if q.Smaller.IsSet {
+		ps = append(ps, sizePredicate("<", q.Smaller.Bytes))
+	}
+	if q.HasAttachment || q.Attachment != (attachmentMatch{}) {
+		ps = append(ps, attachmentPredicate(q.Attachment))
+	}
+	if q.Muted {
+		ps = append(ps, mutedPredicate())
+	}
+	if q.Expr.predicate != nil {
+		ps = append(ps, q.Expr.predicate)
+	}
+	return ps
+}
+
+func flagPredicate(set, clear []rune) predicate {
+	return predicateFunc(func(c *candidate) (bool, error) {
+		for _, flag := range clear {
+			if !c.path.IsClear(flag) {
+				return false, nil
+			}
+		}
+		for _, flag := range set {
+			if !c.path.IsSet(flag) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+func headerPredicate(m headerMatch) predicate {
+	return predicateFunc(func(c *candidate) (bool, error) {
+		header, err := c.Header()
+		if err != nil {
+			return false, err
+		}
+		return m.Match(header), nil
+	})
+}
+
+// timePredicate matches messages received in the interval [since,
+// before).  A zero time leaves that end of the interval open.
+func timePredicate(since, before time.Time) predicate {
+	return predicateFunc(func(c *candidate) (bool, error) {
+		t, err := c.Time()
+		if err != nil {
+			return false, err
+		}
+		if !since.IsZero() && t.Before(since) {
+			return false, nil
+		}
+		if !before.IsZero() && !t.Before(before) {
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+func bodyPredicate(rx regexpFlag) predicate {
+	return predicateFunc(func(c *candidate) (bool, error) {
+		text, err := c.Text()
+		if err != nil {
+			return false, err
+		}
+		return rx.Match(text), nil
+	})
+}
+
+// sizePredicate compares a message's size against n bytes using op,
+// which is one of "<", ">" or "=".
+func sizePredicate(op string, n int64) predicate {
+	return predicateFunc(func(c *candidate) (bool, error) {
+		size, err := c.Size()
+		if err != nil {
+			return false, err
+		}
+		return compareSize(size, op, n), nil
+	})
+}
+
+// attachmentSizePredicate matches messages with an attachment whose
+// size compares against n bytes using op.
+func attachmentSizePredicate(op string, n int64) predicate {
+	return predicateFunc(func(c *candidate) (bool, error) {
+		attachments, err := c.Attachments()
+		if err != nil {
+			return false, err
+		}
+		for _, a := range attachments {
+			if compareSize(a.Size, op, n) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// folderPredicate matches messages in the named folder.  The name
+// can be the folder's full path or just its final element.
+func folderPredicate(name string) predicate {
+	name = filepath.Clean(name)
+	return predicateFunc(func(c *candidate) (bool, error) {
+		folder := filepath.Clean(c.path.Prefix)
+		return folder == name || filepath.Base(folder) == name, nil
+	})
+}
+
+func statePredicate(state string) predicate {
+	return predicateFunc(func(c *candidate) (bool, error) {
+		return c.path.State == state, nil
+	})
+}
+
+// queryExpr is a query expression given on the command line.  For
+// example,
+//
+//    (from:alice or from:bob) and not flag:T and subject:invoice
+//
+// Terms which are next to each other are implicitly joined by "and".
+// Each term has the form field:value.  The supported fields are:
+//
+//    flag:ST                  all these flags are set
+//    is:new                   the message is in new/ (or is:cur)
+//    is:muted                 the message's conversation is muted
+//    folder:inbox             the message is in this folder
+//    since:7d                 received at or after this time (see timeBound)
+//    before:90d               received before this time
+//    size:>1M                 larger than this size (also "<" or exact)
+//    body:regexp              the decoded body matches a regular expression
+//    has:attachment           the message has an attachment
+//    attachment-type:image/*  ... an attachment of this type
+//    attachment-name:*.pdf    ... an attachment with this file name
+//    attachment-size:>5M      ... an attachment of this size
+//
+// Any other field is the name of a header (see parseHeaderMatch).
+// Values containing spaces or parentheses must be double quoted, like
+// subject:"/^(re|fwd):/".
+type queryExpr struct {
+	src       string
+	predicate predicate
+}
+
+func (e *queryExpr) String() string {
+	return e.src
+}
+func (e *queryExpr) Set(arg string) error {
+	p, err := parseQuery(arg)
+	if err != nil {
+		return err
+	}
+	e.src = arg
+	e.predicate = p
+	return nil
+}
+
+// parseQuery compiles a query expression into a predicate.
+func parseQuery(src string) (predicate, error) {
+	tokens, err := lexQuery(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	pred, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q in query", p.peek().text)
+	}
+	return pred, nil
+}
+
+type queryToken struct {
+	text string
+
+	// keyword is true for unquoted "and", "or", "not" and
+	// parentheses.
+	keyword bool
+}
+
+// lexQuery splits a query expression into tokens.
+func lexQuery(src string) ([]queryToken, error) {
+	var tokens []queryToken
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, queryToken{text: string(r), keyword: true})
+			i++
+		default:
+			var word []rune
+			quoted := false
+			for i < len(rs) && !unicode.IsSpace(rs[i]) && rs[i] != '(' && rs[i] != ')' {
+				if rs[i] != '"' {
+					word = append(word, rs[i])
+					i++
+					continue
+				}
+
+				// a quoted section runs until the next unescaped quote
+				quoted = true
+				j := i + 1
+				for j < len(rs) && rs[j] != '"' {
+					if rs[j] == '\\' {
+						j++
+					}
+					j++
+				}
+				if j >= len(rs) {
+					return nil, errors.New("unterminated quote in query")
+				}
+				s, err := strconv.Unquote(string(rs[i : j+1]))
+				if err != nil {
+					return nil, errors.Wrap(err, "invalid quoted string in query")
+				}
+				word = append(word, []rune(s)...)
+				i = j + 1
+			}
+			text := string(word)
+			switch strings.ToLower(text) {
+			case "and", "or", "not":
+				if !quoted {
+					text = strings.ToLower(text)
+					tokens = append(tokens, queryToken{text: text, keyword: true})
+					continue
+				}
+			}
+			tokens = append(tokens, queryToken{text: text})
+		}
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser for query expressions.
+type queryParser struct {
+	tokens []queryToken
+	i      int
+}
+
+func (p *queryParser) done() bool {
+	return p.i >= len(p.tokens)
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.i]
+}
+
+// accept consumes the next token if it's the given keyword.
+func (p *queryParser) accept(keyword string) bool {
+	if p.done() {
+		return false
+	}
+	if t := p.peek(); t.keyword && t.text == keyword {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	ps := orPredicate{left}
+	for p.accept("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, right)
+	}
+	if len(ps) == 1 {
+		return left, nil
+	}
+	return ps, nil
+}
+
+func (p *queryParser) parseAnd() (predicate, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	ps := andPredicate{left}
+	for !p.done() {
+		if t := p.peek(); t.keyword && (t.text == "or" || t.text == ")") {
+			break
+		}
+		p.accept("and")
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, right)
+	}
+	if len(ps) == 1 {
+		return left, nil
+	}
+	return ps, nil
+}
+
+func (p *queryParser) parseUnary() (predicate, error) {
+	if p.done() {
+		return nil, errors.New("unexpected end of query")
+	}
+	if p.accept("not") {
+		pred, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notPredicate{pred}, nil
+	}
+	if p.accept("(") {
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, errors.New("missing ) in query")
+		}
+		return pred, nil
+	}
+
+	t := p.peek()
+	if t.keyword {
+		return nil, fmt.Errorf("unexpected %q in query", t.text)
+	}
+	p.i++
+	return parseQueryTerm(t.text)
+}
+
+// parseQueryTerm compiles a single field:value term.
+func parseQueryTerm(term string) (predicate, error) {
+	parts := strings.SplitN(term, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, fmt.Errorf("expected field:value, got %q", term)
+	}
+	field, value := strings.ToLower(parts[0]), parts[1]
+
+	switch field {
+	case "flag":
+		return flagPredicate([]rune(value), nil), nil
+	case "is":
+		switch value {
+		case "new", "cur":
+			return statePredicate(value), nil
+		case "muted":
+			return mutedPredicate(), nil
+		}
+		return nil, fmt.Errorf("unknown term %q", term)
+	case "folder":
+		return folderPredicate(value), nil
+	case "since", "before":
+		t, err := parseTimeBound(value, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if field == "since" {
+			return timePredicate(t, time.Time{}), nil
+		}
+		return timePredicate(time.Time{}, t), nil
+	case "size":
+		op, n, err := parseSizeComparison(value)
+		if err != nil {
+			return nil, err
+		}
+		return sizePredicate(op, n), nil
+	case "has":
+		if value == "attachment" {
+			return attachmentPredicate(attachmentMatch{}), nil
+		}
+		return nil, fmt.Errorf("unknown term %q", term)
+	case "attachment-type":
+		return attachmentPredicate(attachmentMatch{Type: value}), nil
+	case "attachment-name":
+		return attachmentPredicate(attachmentMatch{Name: value}), nil
+	case "attachment-size":
+		op, n, err := parseSizeComparison(value)
+		if err != nil {
+			return nil, err
+		}
+		return attachmentSizePredicate(op, n), nil
+	case "body":
+		var rx regexpFlag
+		if err := rx.Set(value); err != nil {
+			return nil, err
+		}
+		return bodyPredicate(rx), nil
+	}
+
+	m, err := parseHeaderMatch(term)
+	if err != nil {
+		return nil, err
+	}
+	return headerPredicate(m), nil
+}
+
+// parseSizeComparison parses a size with an optional comparison
+// operator, like ">5M", "<10k" or "512".
+func parseSizeComparison(value string) (op string, n int64, err error) {
+	op = "="
+	if strings.HasPrefix(value, "<") || strings.HasPrefix(value, ">") {
+		op, value = value[:1], value[1:]
+	}
+	n, err = parseSize(value)
+	return op, n, err
+}
+
+// compareSize compares size against n using op.  See
+// parseSizeComparison.
+func compareSize(size int64, op string, n int64) bool {
+	switch op {
+	case "<":
+		return size < n
+	case ">":
+		return size > n
+	default:
+		return size == n
+	}
+}
+
+// parseSize parses a number of bytes with an optional unit suffix,
+// like "512", "10k", "5M" or "1G".
+func parseSize(arg string) (int64, error) {
+	units := map[string]int64{
+		"k": 1 << 10,
+		"m": 1 << 20,
+		"g": 1 << 30,
+	}
+	unit := int64(1)
+	s := strings.ToLower(arg)
+	if len(s) > 0 {
+		if u, ok := units[s[len(s)-1:]]; ok {
+			unit = u
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", arg)
+	}
+	return n * unit, nil
+}
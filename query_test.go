@@ -0,0 +1,74 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"net/mail"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	message := func(path, from, subject string) *candidate {
+		p, err := ParsePath(path)
+		if err != nil {
+			t.Fatalf("can't parse %q: %s", path, err)
+		}
+		return &candidate{
+			path: p,
+			header: mail.Header{
+				"From":    {from},
+				"Subject": {subject},
+				"Date":    {"Wed, 02 May 2018 10:00:00 +0000"},
+			},
+		}
+	}
+	alice := message(`inbox/cur/a:2,S`, `Alice <alice@example.com>`, `Invoice for May`)
+	bob := message(`inbox/new/b:2,T`, `Bob <bob@example.com>`, `Re: lunch (today)`)
+	carol := message(`spam/cur/c:2,`, `Carol <carol@example.com>`, `=?UTF-8?Q?Invoice_f=C3=BCr_May?=`)
+
+	tests := []struct {
+		query    string
+		expected []*candidate
+	}{
+		{`from:alice`, []*candidate{alice}},
+		{`from:alice or from:bob`, []*candidate{alice, bob}},
+		{`(from:alice OR from:bob) and not flag:T`, []*candidate{alice}},
+		{`subject:invoice folder:inbox`, []*candidate{alice}},
+		{`subject:"für may"`, []*candidate{carol}},
+		{`subject:"/\\(today\\)$/"`, []*candidate{bob}},
+		{`is:new or folder:spam`, []*candidate{bob, carol}},
+		{`not (flag:S or flag:T)`, []*candidate{carol}},
+		{`since:2018-05-01 before:2018-05-03`, []*candidate{alice, bob, carol}},
+		{`"not":x`, nil},
+	}
+
+	for _, test := range tests {
+		pred, err := parseQuery(test.query)
+		if err != nil {
+			t.Errorf("%q: %s", test.query, err)
+			continue
+		}
+		var got []*candidate
+		for _, c := range []*candidate{alice, bob, carol} {
+			ok, err := pred.match(c)
+			if err != nil {
+				t.Errorf("%q: %s", test.query, err)
+			}
+			if ok {
+				got = append(got, c)
+			}
+		}
+		if len(got) != len(test.expected) {
+			t.Errorf("%q: matched %d messages, expected %d", test.query, len(got), len(test.expected))
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%q: unexpected match %s", test.query, got[i].path)
+			}
+		}
+	}
+
+	for _, query := range []string{``, `:alice`, `(from:alice`, `flag:S)`, `alice`, `subject:"open`, `size:>lots`} {
+		if _, err := parseQuery(query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
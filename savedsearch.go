@@ -0,0 +1,181 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// savedSearchesName is the file which defines saved searches for the
+// folders in the same directory.  Each line holds a search name
+// followed by the arguments for "mailz find".  For example,
+//
+//    # name          arguments
+//    flagged-unread  -s F -c S inbox
+//    invoices        -q "subject:invoice and not flag:T" -r .
+//
+// Blank lines and lines starting with # are ignored.  A saved search
+// can be used anywhere a folder is accepted by Find.
+const savedSearchesName = ".mailz-searches"
+
+// savedSearch is a query, and the folders it searches, stored under a
+// name.
+type savedSearch struct {
+	Name    string
+	Query   *Query
+	Folders []string
+
+	// Path is the search's name joined with the directory of the file
+	// defining it, which identifies the search.
+	Path string
+}
+
+// lookupSavedSearch finds the saved search referred to by root, a path
+// which doesn't exist in the file system.  If there's no such saved
+// search, it returns nil without an error.
+func lookupSavedSearch(root string) (*savedSearch, error) {
+	dir, name := filepath.Split(root)
+	file := filepath.Join(dir, savedSearchesName)
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "opening saved searches")
+	}
+	defer f.Close()
+
+	lines := bufio.NewScanner(f)
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, n, err)
+		}
+		if args[0] != name {
+			continue
+		}
+
+		search, err := parseSavedSearch(args[0], args[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, n, err)
+		}
+		search.Path = filepath.Join(dir, name)
+		for i, folder := range search.Folders {
+			if !filepath.IsAbs(folder) {
+				search.Folders[i] = filepath.Join(dir, folder)
+			}
+		}
+		return search, nil
+	}
+	if err := lines.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading saved searches")
+	}
+	return nil, nil
+}
+
+func parseSavedSearch(name string, args []string) (*savedSearch, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	q := &Query{}
+	allowQueryArguments(fs, q)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	search := &savedSearch{
+		Name:    name,
+		Query:   q,
+		Folders: fs.Args(),
+	}
+	if len(search.Folders) == 0 {
+		search.Folders = []string{"."}
+	}
+	return search, nil
+}
+
+// find calls fn for each message matching both the saved search and
+// the conditions of query.
+func (search *savedSearch) find(query *Query, fn func(*Path)) error {
+	for i, path := range query.searches {
+		if path == search.Path {
+			loop := append(append([]string{}, query.searches[i:]...), search.Path)
+			return errors.New("saved search loop: " + strings.Join(loop, " -> "))
+		}
+	}
+	searches := make([]string, len(query.searches), len(query.searches)+1)
+	copy(searches, query.searches)
+	searches = append(searches, search.Path)
+
+	outer := query.predicate()
+	if query.OnlyNew {
+		outer = andPredicate{outer, statePredicate("new")}
+	}
+
+	for _, folder := range search.Folders {
+		q := *search.Query
+		q.Root = folder
+		q.Recursive = q.Recursive || query.Recursive
+		q.searches = searches
+		if query.Jobs > q.Jobs {
+			q.Jobs = query.Jobs
+		}
+
+		err := Find(&q, func(path *Path) {
+			ok, err := outer.match(&candidate{path: path})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+				return
+			}
+			if ok {
+				fn(path)
+			}
+		})
+		if err != nil {
+			return errors.Wrap(err, "saved search "+search.Name)
+		}
+	}
+	return nil
+}
+
+// splitArgs splits a line into arguments separated by white space.
+// Single or double quotes group words into a single argument.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var arg []rune
+	inArg := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg = append(arg, r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = arg[:0], false
+			}
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
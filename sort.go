@@ -0,0 +1,136 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sortKeys are the ways messages can be ordered.  Each function
+// returns a string which sorts the same way as the message.
+var sortKeys = map[string]func(*candidate) (string, error){
+	"date": func(c *candidate) (string, error) {
+		header, err := c.Header()
+		if err != nil {
+			return "", err
+		}
+		t, _ := parseHeaderTime("Date", header.Get("Date"))
+		return sortableTime(t), nil
+	},
+	"received": func(c *candidate) (string, error) {
+		t, err := c.Time()
+		if err != nil {
+			return "", err
+		}
+		return sortableTime(t), nil
+	},
+	"from": func(c *candidate) (string, error) {
+		header, err := c.Header()
+		if err != nil {
+			return "", err
+		}
+		return strings.ToLower(decodeHeader(header.Get("From"))), nil
+	},
+	"subject": func(c *candidate) (string, error) {
+		header, err := c.Header()
+		if err != nil {
+			return "", err
+		}
+		subject := normalizeSubject(decodeHeader(header.Get("Subject")))
+		return strings.ToLower(subject), nil
+	},
+	"size": func(c *candidate) (string, error) {
+		size, err := c.Size()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%020d", size), nil
+	},
+	"unique": func(c *candidate) (string, error) {
+		return c.path.Unique, nil
+	},
+}
+
+func sortableTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000")
+}
+
+type byKey struct {
+	paths []*Path
+	keys  []string
+}
+
+func (s byKey) Len() int           { return len(s.paths) }
+func (s byKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s byKey) Swap(i, j int) {
+	s.paths[i], s.paths[j] = s.paths[j], s.paths[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// sortPaths orders messages by the named sort key.  Messages with
+// equal keys stay in their original order.  Messages which can't be
+// read have an empty key.
+func sortPaths(paths []*Path, key string, reverse bool) error {
+	keyFn, ok := sortKeys[key]
+	if !ok {
+		return fmt.Errorf("unknown sort key %q", key)
+	}
+
+	keys := make([]string, len(paths))
+	for i, path := range paths {
+		k, err := keyFn(&candidate{path: path})
+		if err != nil {
+			// sort unreadable messages first rather than fail
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		}
+		keys[i] = k
+	}
+
+	var s sort.Interface = byKey{paths, keys}
+	if reverse {
+		s = sort.Reverse(s)
+	}
+	sort.Stable(s)
+	return nil
+}
+
+// replyPrefixRx matches the reply and forward prefixes used by mail
+// clients in various languages, like "Re:", "Fwd:", "AW:" (German),
+// "SV:" (Scandinavian) or "Antw:" (Dutch).  Some clients number them,
+// like "Re[2]:".
+var replyPrefixRx = regexp.MustCompile(`(?i)^(re|fwd?|aw|wg|sv|vs|antw|doorst|odp|rif|tr)\s*(\[\d+\]|\(\d+\))?\s*[:：]\s*`)
+
+// listTagRx matches a mailing list's tag, like "[golang-nuts] ".
+var listTagRx = regexp.MustCompile(`^\[[^\]]*\]\s*`)
+
+// normalizeSubject reduces a subject to its original form by removing
+// reply and forward prefixes, mailing list tags and differences in
+// white space.  For example, "[list] AW: Re:  Hello" becomes "Hello".
+func normalizeSubject(subject string) string {
+	base, _ := parseSubject(subject)
+	return base
+}
+
+// parseSubject is like normalizeSubject but also reports whether the
+// subject had a reply or forward prefix.
+func parseSubject(subject string) (base string, isReply bool) {
+	subject = strings.Join(strings.Fields(subject), " ")
+	for {
+		if loc := replyPrefixRx.FindStringIndex(subject); loc != nil {
+			subject = subject[loc[1]:]
+			isReply = true
+			continue
+		}
+		if loc := listTagRx.FindStringIndex(subject); loc != nil && loc[1] < len(subject) {
+			subject = subject[loc[1]:]
+			continue
+		}
+		break
+	}
+	if s := strings.TrimSuffix(subject, " (fwd)"); s != subject {
+		subject, isReply = s, true
+	}
+	return subject, isReply
+}
@@ -0,0 +1,153 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// headView is a message as seen by CommandHead's columns and output
+// templates.  For example, the template
+//
+//    {{.Flags}} {{(.Time "Date").Format "Jan 02"}} {{.Name "From"}}: {{.Header "Subject"}}
+//
+// renders a typical list line.
+type headView struct {
+	path   *Path
+	header mail.Header
+}
+
+// Header returns the decoded value of a header.  The name may have an
+// index, like "Received[-1]", to choose among several occurrences.
+func (v *headView) Header(name string) string {
+	return decodeHeader(v.field(name))
+}
+
+// Headers returns the decoded value of every occurrence of a header,
+// in order.
+func (v *headView) Headers(name string) []string {
+	var values []string
+	for _, value := range v.header[textproto.CanonicalMIMEHeaderKey(name)] {
+		values = append(values, decodeHeader(value))
+	}
+	return values
+}
+
+// BaseSubject returns the Subject header without reply prefixes and
+// list tags.
+func (v *headView) BaseSubject() string {
+	return normalizeSubject(v.Header("Subject"))
+}
+
+// field returns the raw value of a header.
+func (v *headView) field(name string) string {
+	return headerField(v.header, name)
+}
+
+// Addresses parses the addresses in a header.
+func (v *headView) Addresses(name string) []*mail.Address {
+	value := v.field(name)
+	if value == "" {
+		return nil
+	}
+	addresses, err := parseAddressList(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid address: %q\n", value)
+		return nil
+	}
+	return addresses
+}
+
+// Name returns the display name of the first address in a header,
+// falling back to its email address.
+func (v *headView) Name(name string) string {
+	addresses := v.Addresses(name)
+	if len(addresses) == 0 {
+		return ""
+	}
+	if addresses[0].Name != "" {
+		return addresses[0].Name
+	}
+	return addresses[0].Address
+}
+
+// Time parses the date in a header, like a -t column.  The name may
+// also be "delivered" or "mtime".
+func (v *headView) Time(name string) time.Time {
+	t, err := v.timeOf(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid date: %s\n", err)
+	}
+	return t
+}
+
+// timeOf returns the time from source: a header name, "delivered" or
+// "mtime".
+func (v *headView) timeOf(source string) (time.Time, error) {
+	switch strings.ToLower(source) {
+	case sourceDelivered:
+		return deliveryTime(v.path.Unique)
+	case sourceModified:
+		info, err := os.Stat(v.path.String())
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime(), nil
+	}
+	value := v.Header(source)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("no %s header", source)
+	}
+	t, err := parseHeaderTime(source, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %q", source, value)
+	}
+	return t, nil
+}
+
+func (v *headView) Flags() string  { return v.path.FlagString() }
+func (v *headView) Unique() string { return v.path.Unique }
+func (v *headView) Path() string   { return v.path.String() }
+func (v *headView) Folder() string { return v.path.Folder() }
+func (v *headView) State() string  { return v.path.State }
+
+// Size returns the message's size in bytes.
+func (v *headView) Size() (int64, error) {
+	return (&candidate{path: v.path}).Size()
+}
+
+// templateFuncs are the extra functions available to output templates.
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"pad": func(n int, s string) string {
+		if n < 0 {
+			return fmt.Sprintf("%*s", -n, s)
+		}
+		return fmt.Sprintf("%-*s", n, s)
+	},
+	"trunc": func(n int, s string) string {
+		r := []rune(s)
+		if len(r) > n {
+			return string(r[:n])
+		}
+		return s
+	},
+}
+
+// parseHeadTemplate parses an output template for CommandHead.
+func parseHeadTemplate(text string) (*template.Template, error) {
+	return template.New("head").Funcs(templateFuncs).Parse(text)
+}
+
+// formatHeadTemplate renders a message's header with tmpl.
+func formatHeadTemplate(tmpl *template.Template, path *Path, header mail.Header) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, &headView{path: path, header: header})
+	return buf.String(), err
+}
@@ -0,0 +1,438 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Thread is a message within a conversation tree.  See Threads.
+type Thread struct {
+	// MessageID identifies this message, without angle brackets.  It's
+	// empty for a placeholder which gathers messages that share a
+	// subject but don't refer to each other.
+	MessageID string
+
+	// Path is the message's location.  It's nil if the message is
+	// referenced by other messages but wasn't found, or for a subject
+	// placeholder.
+	Path *Path
+
+	// Subject is the message's decoded subject.  For a subject
+	// placeholder, it's the shared subject without reply prefixes.
+	Subject string
+
+	// Date is when the message was received.  For a missing message,
+	// it's the earliest date of its children.
+	Date time.Time
+
+	Parent   *Thread
+	Children []*Thread
+}
+
+// Walk calls fn for this message and each of its descendants, depth
+// first, along with its depth below this one.
+func (t *Thread) Walk(fn func(t *Thread, depth int)) {
+	var walk func(*Thread, int)
+	walk = func(t *Thread, depth int) {
+		fn(t, depth)
+		for _, child := range t.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(t, 0)
+}
+
+// Root returns the top of this message's conversation.
+func (t *Thread) Root() *Thread {
+	for t.Parent != nil {
+		t = t.Parent
+	}
+	return t
+}
+
+// threadMessage is a message, and the messages it refers to, as input
+// to threading.
+type threadMessage struct {
+	Thread *Thread
+
+	// References are the Message-IDs of this message's ancestors,
+	// oldest first.
+	References []string
+
+	// IsReply is true if the message's subject had a reply prefix.
+	IsReply bool
+}
+
+// Threads groups the messages matching query into conversations, based
+// on their Message-ID, In-Reply-To and References headers, following
+// https://www.jwz.org/doc/threading.html
+//
+// The result is the top message of each conversation, ordered by date.
+// Replies are likewise ordered by date.
+func Threads(query *Query) ([]*Thread, error) {
+	return threadFolders(query, []string{query.Root}, true)
+}
+
+// threadFolders is like Threads but a conversation can span several
+// folders.  If bySubject is false, conversations are linked only by
+// references, not by sharing a subject.
+func threadFolders(query *Query, folders []string, bySubject bool) ([]*Thread, error) {
+	var messages []*threadMessage
+	for _, folder := range folders {
+		q := *query
+		q.Root = folder
+		err := Find(&q, func(path *Path) {
+			m, err := readThreadMessage(&candidate{path: path})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+				return
+			}
+			messages = append(messages, m)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return thread(messages, bySubject), nil
+}
+
+// expandThreads replaces each message with all the messages in its
+// conversation.  Conversations are sought in each message's own folder
+// plus any extra folders.  Since the result is usually changed, only
+// messages linked by Message-ID, In-Reply-To and References count as
+// one conversation.  Messages merely sharing a subject don't.  Copies
+// of a message in the conversation, with the same Message-ID, are
+// included too.
+func expandThreads(paths []*Path, extra []string) ([]*Path, error) {
+	var expanded []*Path
+	seen := make(map[string]bool)
+	byFolder := make(map[string]map[string]*Thread)       // folder -> unique -> message
+	copiesByFolder := make(map[string]map[string][]*Path) // folder -> Message-ID -> paths
+	for _, path := range paths {
+		folder := path.Folder()
+
+		// thread each folder's messages just once
+		messages, ok := byFolder[folder]
+		copies := copiesByFolder[folder]
+		if !ok {
+			roots, err := threadFolders(&Query{}, append([]string{folder}, extra...), false)
+			if err != nil {
+				return nil, err
+			}
+			messages = make(map[string]*Thread)
+			copies = make(map[string][]*Path)
+			for _, root := range roots {
+				root.Walk(func(t *Thread, depth int) {
+					if t.Path != nil {
+						messages[t.Path.Unique] = t
+						copies[t.MessageID] = append(copies[t.MessageID], t.Path)
+					}
+				})
+			}
+			byFolder[folder] = messages
+			copiesByFolder[folder] = copies
+		}
+
+		t, ok := messages[path.Unique]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in %s", path, folder)
+		}
+
+		// a copy may sit in another conversation, if it lacks the
+		// references of the original, so take that one too
+		roots := []*Thread{t.Root()}
+		for len(roots) > 0 {
+			root := roots[len(roots)-1]
+			roots = roots[:len(roots)-1]
+			root.Walk(func(t *Thread, depth int) {
+				if t.Path == nil {
+					return
+				}
+				for _, path := range copies[t.MessageID] {
+					if !seen[path.String()] {
+						seen[path.String()] = true
+						expanded = append(expanded, path)
+						roots = append(roots, messages[path.Unique].Root())
+					}
+				}
+			})
+		}
+	}
+	return expanded, nil
+}
+
+func readThreadMessage(c *candidate) (*threadMessage, error) {
+	header, err := c.Header()
+	if err != nil {
+		return nil, err
+	}
+	date, err := c.Time()
+	if err != nil {
+		return nil, err
+	}
+
+	id := normalizeMessageID(header.Get("Message-Id"))
+	if id == "" {
+		// every message needs an ID, so invent one
+		id = "mailz." + c.path.Unique
+	}
+	references := messageIDs(header.Get("References"))
+	if parents := messageIDs(header.Get("In-Reply-To")); len(parents) > 0 {
+		parent := parents[0]
+		if len(references) == 0 || references[len(references)-1] != parent {
+			references = append(references, parent)
+		}
+	}
+	subject := decodeHeader(header.Get("Subject"))
+	_, isReply := parseSubject(subject)
+
+	m := &threadMessage{
+		Thread: &Thread{
+			MessageID: id,
+			Path:      c.path,
+			Subject:   subject,
+			Date:      date,
+		},
+		References: references,
+		IsReply:    isReply,
+	}
+	return m, nil
+}
+
+var messageIDRx = regexp.MustCompile(`<[^<>]+>`)
+
+// messageIDs extracts the Message-IDs, without angle brackets, from
+// a header like References.
+func messageIDs(v string) []string {
+	var ids []string
+	for _, id := range messageIDRx.FindAllString(v, -1) {
+		if id = normalizeMessageID(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// thread arranges messages into conversation trees.  If bySubject is
+// true, conversations without references are merged by subject too.
+func thread(messages []*threadMessage, bySubject bool) []*Thread {
+	containers := make(map[string]*Thread)
+	container := func(id string) *Thread {
+		t, ok := containers[id]
+		if !ok {
+			t = &Thread{MessageID: id}
+			containers[id] = t
+		}
+		return t
+	}
+	isReply := make(map[*Thread]bool)
+
+	for i, m := range messages {
+		// find this message's container
+		t := container(m.Thread.MessageID)
+		if t.Path != nil {
+			// a duplicate Message-ID.  treat it as a distinct message
+			t = container(fmt.Sprintf("%s\x00%d", m.Thread.MessageID, i))
+			t.MessageID = m.Thread.MessageID
+		}
+		t.Path, t.Subject, t.Date = m.Thread.Path, m.Thread.Subject, m.Thread.Date
+		m.Thread = t
+		isReply[t] = m.IsReply
+
+		// link the references together, without clobbering links
+		// which are already known
+		var parent *Thread
+		for _, id := range m.References {
+			ref := container(id)
+			if parent != nil && ref != parent && ref.Parent == nil && !ref.isAncestorOf(parent) {
+				parent.adopt(ref)
+			}
+			parent = ref
+		}
+
+		// the final reference is this message's parent
+		if parent != nil && (parent == t || t.isAncestorOf(parent)) {
+			parent = nil
+		}
+		if t.Parent != nil {
+			t.Parent.disown(t)
+		}
+		if parent != nil {
+			parent.adopt(t)
+		}
+	}
+
+	// gather the root set and prune missing messages
+	var roots []*Thread
+	for _, t := range containers {
+		if t.Parent == nil {
+			roots = append(roots, t)
+		}
+	}
+	roots = prune(roots, true)
+	sortThreads(roots)
+	if bySubject {
+		roots = groupBySubject(roots, isReply)
+	}
+
+	for _, root := range roots {
+		root.Walk(func(t *Thread, depth int) {
+			sortThreads(t.Children)
+		})
+	}
+	sortThreads(roots)
+	return roots
+}
+
+// isAncestorOf returns true if t is an ancestor of other.
+func (t *Thread) isAncestorOf(other *Thread) bool {
+	for p := other.Parent; p != nil; p = p.Parent {
+		if p == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Thread) adopt(child *Thread) {
+	child.Parent = t
+	t.Children = append(t.Children, child)
+}
+
+func (t *Thread) disown(child *Thread) {
+	for i, c := range t.Children {
+		if c == child {
+			t.Children = append(t.Children[:i], t.Children[i+1:]...)
+			break
+		}
+	}
+	child.Parent = nil
+}
+
+// prune removes missing messages from a list of siblings.  A missing
+// message's children take its place, except at the top level where a
+// missing message with several children holds the conversation
+// together.
+func prune(siblings []*Thread, top bool) []*Thread {
+	var kept []*Thread
+	for _, t := range siblings {
+		t.Children = prune(t.Children, false)
+		if t.Path != nil {
+			kept = append(kept, t)
+			continue
+		}
+		switch {
+		case len(t.Children) == 0:
+			// nothing to keep
+		case top && len(t.Children) > 1:
+			kept = append(kept, t)
+		default:
+			for _, child := range t.Children {
+				child.Parent = t.Parent
+			}
+			kept = append(kept, t.Children...)
+		}
+	}
+	for _, t := range kept {
+		if t.Path == nil {
+			t.Date = t.Children[0].Date
+			for _, child := range t.Children {
+				if child.Date.Before(t.Date) {
+					t.Date = child.Date
+				}
+			}
+		}
+	}
+	return kept
+}
+
+// groupBySubject merges top level conversations which share a subject
+// but lack references, as happens with mail clients that don't set
+// them.
+func groupBySubject(roots []*Thread, isReply map[*Thread]bool) []*Thread {
+	subjectOf := func(t *Thread) string {
+		if t.Path == nil && len(t.Children) > 0 {
+			t = t.Children[0]
+		}
+		return strings.ToLower(normalizeSubject(t.Subject))
+	}
+
+	// which conversations share a subject?
+	bySubject := make(map[string][]*Thread)
+	for _, t := range roots {
+		if subject := subjectOf(t); subject != "" {
+			bySubject[subject] = append(bySubject[subject], t)
+		}
+	}
+
+	var merged []*Thread
+	for _, t := range roots {
+		subject := subjectOf(t)
+		group := bySubject[subject]
+		if len(group) < 2 {
+			merged = append(merged, t)
+			continue
+		}
+		if t != group[0] {
+			continue // merged along with the group's first member
+		}
+
+		// the new parent is a missing message, if there is one, or the
+		// only message that's not a reply
+		var parent *Thread
+		var originals []*Thread
+		for _, g := range group {
+			if g.Path == nil && parent == nil {
+				parent = g
+			}
+			if g.Path != nil && !isReply[g] {
+				originals = append(originals, g)
+			}
+		}
+		if parent == nil && len(originals) == 1 {
+			parent = originals[0]
+		}
+		if parent == nil {
+			// neither is clearly the parent, so they become siblings
+			// beneath a placeholder
+			parent = &Thread{
+				Subject: normalizeSubject(group[0].Subject),
+				Date:    group[0].Date,
+			}
+		}
+
+		for _, g := range group {
+			switch {
+			case g == parent:
+			case g.Path == nil:
+				for _, child := range g.Children {
+					parent.adopt(child)
+				}
+				g.Children = nil
+			default:
+				parent.adopt(g)
+			}
+		}
+		merged = append(merged, parent)
+	}
+	return merged
+}
+
+// byDate sorts threads by date, then by Message-ID for stability.
+type byDate []*Thread
+
+func (ts byDate) Len() int      { return len(ts) }
+func (ts byDate) Swap(i, j int) { ts[i], ts[j] = ts[j], ts[i] }
+func (ts byDate) Less(i, j int) bool {
+	if !ts[i].Date.Equal(ts[j].Date) {
+		return ts[i].Date.Before(ts[j].Date)
+	}
+	return ts[i].MessageID < ts[j].MessageID
+}
+
+func sortThreads(ts []*Thread) {
+	sort.Sort(byDate(ts))
+}
@@ -0,0 +1,128 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThread(t *testing.T) {
+	start := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	var messages []*threadMessage
+	add := func(id, subject, references string) {
+		path, err := ParsePath("cur/" + id + ":2,")
+		if err != nil {
+			t.Fatalf("can't parse path for %s: %s", id, err)
+		}
+		messages = append(messages, &threadMessage{
+			Thread: &Thread{
+				MessageID: id,
+				Path:      path,
+				Subject:   subject,
+				Date:      start.Add(time.Duration(len(messages)) * time.Hour),
+			},
+			References: messageIDs(references),
+			IsReply:    strings.HasPrefix(subject, "Re: "),
+		})
+	}
+
+	// replies arrive before the messages they reply to
+	add("c", "Re: lunch", "<a> <b>")
+	add("a", "lunch", "")
+	add("b", "Re: lunch", "<a>")
+	add("d", "Re: lunch", "<a>")
+
+	// a reply to a message we don't have
+	add("f", "Re: party", "<e>")
+
+	// a conversation from a client without references
+	add("g", "status", "")
+	add("h", "Re: status", "")
+
+	// two replies to a message we don't have
+	add("j", "Re: picnic", "<i>")
+	add("k", "Re: picnic", "<i>")
+
+	// unrelated messages which happen to share a subject
+	add("m", "Meeting", "")
+	add("n", "meeting", "")
+
+	var lines []string
+	for _, root := range thread(messages, true) {
+		root.Walk(func(t *Thread, depth int) {
+			name := t.MessageID
+			if t.Path == nil && name == "" {
+				name = "(subject: " + t.Subject + ")"
+			} else if t.Path == nil {
+				name = "<" + name + ">"
+			}
+			lines = append(lines, strings.Repeat(" ", depth)+name)
+		})
+	}
+	got := strings.Join(lines, "\n")
+	expected := strings.Join([]string{
+		"a",
+		" b",
+		"  c",
+		" d",
+		"f",
+		"g",
+		" h",
+		"<i>",
+		" j",
+		" k",
+		"(subject: Meeting)",
+		" m",
+		" n",
+	}, "\n")
+	if got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestExpandThreads(t *testing.T) {
+	root := testMaildir(t, map[string]string{
+		"cur/1.a:2,": "Message-ID: <1@a>\nFrom: alice@example.com\nSubject: Meeting\nDate: Tue, 1 May 2018 10:00:00 +0000\n\nhi\n",
+		"cur/2.a:2,": "Message-ID: <2@b>\nFrom: bob@example.com\nSubject: Meeting\nDate: Wed, 2 May 2018 10:00:00 +0000\n\nhi\n",
+		"cur/3.a:2,": "Message-ID: <3@a>\nIn-Reply-To: <1@a>\nSubject: Re: Meeting\nDate: Thu, 3 May 2018 10:00:00 +0000\n\nhi\n",
+		"cur/4.a:2,": "Message-ID: <4@c>\nSubject: Re: Meeting\nDate: Fri, 4 May 2018 10:00:00 +0000\n\nhi\n",
+
+		// copies of a message in the conversation, without references
+		"cur/5.a:2,": "Message-ID: <1@a>\nFrom: alice@example.com\nSubject: Meeting\nDate: Tue, 1 May 2018 10:00:00 +0000\n\nhi\n",
+		"cur/6.a:2,": "Message-ID: <3@a>\nSubject: Re: Meeting\nDate: Thu, 3 May 2018 10:00:00 +0000\n\nhi\n",
+	})
+	defer os.RemoveAll(root)
+
+	expand := func(name string) []string {
+		path, err := ParsePath(filepath.Join(root, "cur", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expanded, err := expandThreads([]*Path{path}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var uniques []string
+		for _, p := range expanded {
+			uniques = append(uniques, p.Unique)
+		}
+		sort.Strings(uniques)
+		return uniques
+	}
+	tests := map[string][]string{
+		"1.a:2,": {"1.a", "3.a", "5.a", "6.a"},
+		"2.a:2,": {"2.a"},
+		"3.a:2,": {"1.a", "3.a", "5.a", "6.a"},
+		"4.a:2,": {"4.a"},
+		"5.a:2,": {"1.a", "3.a", "5.a", "6.a"},
+		"6.a:2,": {"1.a", "3.a", "5.a", "6.a"},
+	}
+	for name, expected := range tests {
+		if got := expand(name); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: got %q, expected %q", name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package mailz // import "github.com/mndrix/mailz"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Besides header names, a time column's source can be one of these.
+const (
+	// sourceDelivered is the delivery time encoded in a message's unique.
+	sourceDelivered = "delivered"
+
+	// sourceModified is the message file's mtime.
+	sourceModified = "mtime"
+)
+
+// timeFormat describes how CommandHead renders a time column.
+type timeFormat struct {
+	// Layout is a layout for time.Format, "unix" for seconds since the
+	// epoch or "ago" for a relative time like "3h ago".
+	Layout string
+
+	// Location is the time zone for output.  If it's nil, times keep
+	// the offset they were parsed with.
+	Location *time.Location
+
+	// Fallback lists the sources to try, in order, when a column's own
+	// source has no valid time.
+	Fallback []string
+}
+
+// defaultTimeFormat is the format used unless -layout, -tz or -fallback
+// says otherwise.
+var defaultTimeFormat = timeFormat{
+	Layout:   time.RFC3339,
+	Location: time.UTC,
+}
+
+// setLayout sets the layout, accepting a few names for common ones.
+func (f *timeFormat) setLayout(layout string) {
+	switch strings.ToLower(layout) {
+	case "rfc3339":
+		layout = time.RFC3339
+	case "rfc822", "rfc5322":
+		layout = time.RFC1123Z
+	}
+	f.Layout = layout
+}
+
+// setZone sets the time zone: "local", "original" or a zone name like
+// "UTC" or "Europe/Berlin".
+func (f *timeFormat) setZone(zone string) error {
+	switch strings.ToLower(zone) {
+	case "local":
+		f.Location = time.Local
+	case "original":
+		f.Location = nil
+	default:
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return err
+		}
+		f.Location = loc
+	}
+	return nil
+}
+
+// resolve returns the first valid time among source and the fallbacks.
+func (f *timeFormat) resolve(m *headView, source string) (time.Time, error) {
+	t, err := m.timeOf(source)
+	if err == nil {
+		return t, nil
+	}
+	for _, fallback := range f.Fallback {
+		if t, ferr := m.timeOf(fallback); ferr == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// format renders t, relative to now for the "ago" layout.
+func (f *timeFormat) format(t, now time.Time) string {
+	if f.Location != nil {
+		t = t.In(f.Location)
+	}
+	switch f.Layout {
+	case "ago":
+		return formatAgo(now.Sub(t))
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return t.Format(f.Layout)
+}
+
+// column returns the functions for a column of times from source.
+func (f timeFormat) column(source string) (filter func(*headView, string, string) string, value func(*headView, string, string) interface{}) {
+	filter = func(m *headView, h, v string) string {
+		t, err := f.resolve(m, source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid date: %s\n", err)
+			return ""
+		}
+		return f.format(t, time.Now())
+	}
+	value = func(m *headView, h, v string) interface{} {
+		t, err := f.resolve(m, source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid date: %s\n", err)
+			return nil
+		}
+		if f.Location != nil {
+			t = t.In(f.Location)
+		}
+		return t.Format(time.RFC3339)
+	}
+	return filter, value
+}
+
+// formatAgo renders a duration in the past like "3h ago".  Future
+// times, as from a bad clock, render like "in 3h".
+func formatAgo(d time.Duration) string {
+	future := d < 0
+	if future {
+		d = -d
+	}
+	var s string
+	switch {
+	case d < time.Minute:
+		s = fmt.Sprintf("%ds", d/time.Second)
+	case d < time.Hour:
+		s = fmt.Sprintf("%dm", d/time.Minute)
+	case d < 48*time.Hour:
+		s = fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		s = fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	if future {
+		return "in " + s
+	}
+	return s + " ago"
+}
+
+// deliveryTime extracts the delivery time from a standard maildir
+// unique, which begins with the seconds since the epoch.
+func deliveryTime(unique string) (time.Time, error) {
+	i := strings.Index(unique, ".")
+	if i < 1 {
+		return time.Time{}, errors.New("no delivery time in " + unique)
+	}
+	secs, err := strconv.ParseInt(unique[:i], 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("no delivery time in " + unique)
+	}
+	return time.Unix(secs, 0), nil
+}